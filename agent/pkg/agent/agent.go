@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -9,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -21,17 +23,28 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
+
 type Task struct {
-	ID            int     `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID            int       `json:"id"`
+	Arg1          float64   `json:"arg1"`
+	Arg2          float64   `json:"arg2"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	Args          []float64 `json:"args,omitempty"`
+	Precision     string    `json:"precision,omitempty"`
+	ExactArgs     []string  `json:"exact_args,omitempty"`
+	IntArgs       []int64   `json:"int_args,omitempty"`
+	// encoding/json не умеет записывать complex128.
+	ComplexArgs []complex128 `json:"-"`
 }
 
 type Result struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+	ID        int         `json:"id"`
+	Result    float64     `json:"result"`
+	Exact     string      `json:"exact,omitempty"`
+	IntResult int64       `json:"int_result,omitempty"`
+	Overflow  bool        `json:"overflow,omitempty"`
+	Complex   *complex128 `json:"-"`
 }
 
 func RunGrpcAgent(power int, delay int, host string) {
@@ -65,7 +78,7 @@ func runGrpcAgentInternal(power int, delay int, host string, apiKey string) {
 			}
 			defer agentConn.Close()
 			agentClient := pb.NewCalculatorServiceClient(agentConn)
-			startGrpcAgent(agentClient, delay)
+			startGrpcAgent(agentClient, delay, agentName(fmt.Sprintf("agent-%d", agentNum)))
 		}(i)
 		// Задержка в 1 секунду перед запуском следующего агента
 		if i < 2 {
@@ -86,7 +99,7 @@ func runGrpcAgentInternal(power int, delay int, host string, apiKey string) {
 		}
 		defer aiConn.Close()
 		aiClient := pb.NewCalculatorServiceClient(aiConn)
-		startGrpcAgentAI(aiClient, delay, apiKey)
+		startGrpcAgentAI(aiClient, delay, apiKey, agentName("ai"))
 	}()
 	time.Sleep(1 * time.Second)
 
@@ -127,7 +140,7 @@ func createAgentConnection(host string, port string) (*grpc.ClientConn, error) {
 	return conn, nil
 }
 
-func startGrpcAgent(client pb.CalculatorServiceClient, delay int) {
+func startGrpcAgent(client pb.CalculatorServiceClient, delay int, name string) {
 	ctx := context.TODO()
 
 	for {
@@ -155,6 +168,11 @@ func startGrpcAgent(client pb.CalculatorServiceClient, delay int) {
 			Arg2:          float64(req.Arg2),
 			Operation:     req.Operation,
 			OperationTime: int(req.OperationTime),
+			Args:          req.Args,
+			Precision:     req.Precision,
+			ExactArgs:     req.ExactArgs,
+			IntArgs:       req.IntArgs,
+			ComplexArgs:   complexArgs(req.ComplexArgs),
 		}
 
 		operationTimer := time.NewTimer(time.Duration(task.OperationTime * int(time.Millisecond)))
@@ -162,13 +180,24 @@ func startGrpcAgent(client pb.CalculatorServiceClient, delay int) {
 
 		result, err := executeTask(task)
 		if err != nil {
-			log.Printf("Ошибка выполнения задачи")
+			// Повтор задачи даст ту же ошибку, поэтому о ней сообщается серверу.
+			log.Printf("Ошибка выполнения задачи %d: %v", task.ID, err)
+			_, err = client.GetResult(ctx, &pb.TaskResult{Id: int32(task.ID), Error: err.Error(), Agent: name})
+			if err != nil {
+				log.Printf("Ошибка отправки результата задачи")
+				Delay(delay)
+			}
 			continue
 		}
 
 		_, err = client.GetResult(ctx, &pb.TaskResult{
-			Id:     int32(result.ID),
-			Result: float32(result.Result),
+			Id:            int32(result.ID),
+			Result:        float32(result.Result),
+			ExactResult:   result.Exact,
+			IntResult:     result.IntResult,
+			Overflow:      result.Overflow,
+			ComplexResult: complexResult(result.Complex),
+			Agent:         name,
 		})
 
 		if err != nil {
@@ -181,7 +210,7 @@ func startGrpcAgent(client pb.CalculatorServiceClient, delay int) {
 	}
 }
 
-func startGrpcAgentAI(client pb.CalculatorServiceClient, delay int, apiKey string) {
+func startGrpcAgentAI(client pb.CalculatorServiceClient, delay int, apiKey string, name string) {
 	ctx := context.TODO()
 
 	for {
@@ -209,6 +238,11 @@ func startGrpcAgentAI(client pb.CalculatorServiceClient, delay int, apiKey strin
 			Arg2:          float64(req.Arg2),
 			Operation:     req.Operation,
 			OperationTime: int(req.OperationTime),
+			Args:          req.Args,
+			Precision:     req.Precision,
+			ExactArgs:     req.ExactArgs,
+			IntArgs:       req.IntArgs,
+			ComplexArgs:   complexArgs(req.ComplexArgs),
 		}
 
 		operationTimer := time.NewTimer(time.Duration(task.OperationTime * int(time.Millisecond)))
@@ -221,8 +255,14 @@ func startGrpcAgentAI(client pb.CalculatorServiceClient, delay int, apiKey strin
 		}
 
 		_, err = client.GetResult(ctx, &pb.TaskResult{
-			Id:     int32(result.ID),
-			Result: float32(result.Result),
+			Id:            int32(result.ID),
+			Result:        float32(result.Result),
+			ExactResult:   result.Exact,
+			IntResult:     result.IntResult,
+			Overflow:      result.Overflow,
+			ComplexResult: complexResult(result.Complex),
+			Agent:         name,
+			Ai:            true,
 		})
 
 		if err != nil {
@@ -235,12 +275,34 @@ func startGrpcAgentAI(client pb.CalculatorServiceClient, delay int, apiKey strin
 	}
 }
 
+// agentName возвращает имя вида "host/agent-1" или "host/ai"
+func agentName(suffix string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "/" + suffix
+}
+
 func Delay(delay int) {
 	delayTimer := time.NewTimer(time.Duration(delay * int(time.Millisecond)))
 	<-delayTimer.C
 }
 
 func executeTask(task Task) (Result, error) {
+	if task.Precision == PrecisionDecimal || task.Precision == PrecisionRational {
+		return executeExact(task)
+	}
+	if task.Precision == PrecisionInteger {
+		return executeInteger(task)
+	}
+	if task.Precision == PrecisionComplex {
+		return executeComplex(task)
+	}
+	if task.Precision != "" {
+		return Result{}, fmt.Errorf("неизвестный режим вычисления: %s", task.Precision)
+	}
+
 	var result float64
 	switch task.Operation {
 	case "+":
@@ -251,13 +313,136 @@ func executeTask(task Task) (Result, error) {
 		result = task.Arg1 * task.Arg2
 	case "/":
 		result = task.Arg1 / task.Arg2
+	case "//":
+		result = math.Floor(task.Arg1 / task.Arg2)
+	case "%":
+		result = math.Mod(task.Arg1, task.Arg2)
+	case "^":
+		result = math.Pow(task.Arg1, task.Arg2)
+	case "neg":
+		result = -task.Arg1
+	case "==", "!=", "<", "<=", ">", ">=":
+		result = float64(truth(comparisons[task.Operation](cmp.Compare(task.Arg1, task.Arg2))))
+	case "!":
+		result = float64(truth(task.Arg1 == 0))
 	default:
-		return Result{}, fmt.Errorf("неизвестная операция: %s", task.Operation)
+		var err error
+		result, err = executeFunction(task.Operation, task.Args)
+		if err != nil {
+			return Result{}, err
+		}
 	}
 
 	return Result{ID: task.ID, Result: result}, nil
 }
 
+func executeFunction(name string, args []float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("неизвестная операция: %s", name)
+	}
+	switch name {
+	case "sqrt":
+		return math.Sqrt(args[0]), nil
+	case "sin":
+		return math.Sin(args[0]), nil
+	case "cos":
+		return math.Cos(args[0]), nil
+	case "log":
+		if len(args) == 2 {
+			return math.Log(args[0]) / math.Log(args[1]), nil
+		}
+		return math.Log(args[0]), nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "min":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	case "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	case "round":
+		return math.Round(args[0]), nil
+	}
+	return 0, fmt.Errorf("неизвестная операция: %s", name)
+}
+
+func complexArgs(args []*pb.Complex) []complex128 {
+	result := make([]complex128, len(args))
+	for i, arg := range args {
+		result[i] = complex(arg.GetReal(), arg.GetImag())
+	}
+	return result
+}
+
+func complexResult(result *complex128) *pb.Complex {
+	if result == nil {
+		return nil
+	}
+	return &pb.Complex{Real: real(*result), Imag: imag(*result)}
+}
+
+func describeTask(task Task) string {
+	args := describeArgs(task)
+	switch task.Operation {
+	case "neg":
+		return fmt.Sprintf("-(%s)", args[0])
+	case "~":
+		return fmt.Sprintf("побитовое отрицание ~%s", args[0])
+	case "!":
+		return fmt.Sprintf("логическое отрицание: верно ли, что %s равно 0", args[0])
+	case "==", "!=", "<", "<=", ">", ">=":
+		return fmt.Sprintf("верно ли, что %s %s %s", args[0], task.Operation, args[1])
+	case "//":
+		return fmt.Sprintf("целая часть (округление вниз) от деления %s на %s", args[0], args[1])
+	case "%":
+		return fmt.Sprintf("остаток от деления %s на %s (знак остатка совпадает со знаком делимого)", args[0], args[1])
+	case "^":
+		return fmt.Sprintf("%s в степени %s", args[0], args[1])
+	}
+	if len(task.Args) > 0 {
+		if task.Operation == "log" && len(args) == 1 {
+			return fmt.Sprintf("натуральный логарифм ln(%s)", args[0])
+		}
+		return fmt.Sprintf("%s(%s)", task.Operation, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", args[0], task.Operation, args[1])
+}
+
+func describeArgs(task Task) []string {
+	if task.Precision == PrecisionInteger {
+		args := make([]string, len(task.IntArgs))
+		for i, arg := range task.IntArgs {
+			args[i] = strconv.FormatInt(arg, 10)
+		}
+		return args
+	}
+	if task.Precision == PrecisionComplex {
+		args := make([]string, len(task.ComplexArgs))
+		for i, arg := range task.ComplexArgs {
+			args[i] = "(" + formatComplex(arg) + ")"
+		}
+		return args
+	}
+	if task.Precision != "" {
+		return task.ExactArgs
+	}
+	values := task.Args
+	if len(values) == 0 {
+		values = []float64{task.Arg1, task.Arg2}
+	}
+	args := make([]string, len(values))
+	for i, value := range values {
+		args[i] = fmt.Sprintf("%.2f", value)
+	}
+	return args
+}
+
 // Структуры для работы с API нейросети
 type ChatCompletionRequest struct {
 	Model    string    `json:"model"`
@@ -285,8 +470,21 @@ func executeTaskAI(task Task, apiKey string) (Result, error) {
 	}
 	
 	// Формируем запрос к нейросети
-	taskDescription := fmt.Sprintf("Реши математическую задачу: %.2f %s %.2f. Верни только число-результат без дополнительных объяснений.",
-		task.Arg1, task.Operation, task.Arg2)
+	taskDescription := fmt.Sprintf("Реши математическую задачу: %s. Верни только число-результат без дополнительных объяснений.",
+		describeTask(task))
+	if _, found := comparisons[task.Operation]; found || task.Operation == "!" {
+		taskDescription += " Ответь 1, если верно, и 0, если неверно."
+	}
+	switch task.Precision {
+	case PrecisionDecimal:
+		taskDescription += fmt.Sprintf(" Вычисли точно и запиши результат десятичной дробью, бесконечную дробь округли до %d знаков после запятой.", DecimalDigits)
+	case PrecisionRational:
+		taskDescription += " Вычисли точно и запиши результат несократимой дробью вида a/b или целым числом."
+	case PrecisionInteger:
+		taskDescription += " Считай в 64-битных целых числах со знаком, деление отбрасывает дробную часть. Если результат не помещается в 64 бита, ответь OVERFLOW."
+	case PrecisionComplex:
+		taskDescription += " Считай в комплексных числах (i - мнимая единица, для корня и логарифма бери главное значение) и запиши результат в виде a+bi, например 11-2i."
+	}
 
 	requestBody := ChatCompletionRequest{
 		Model: "anthropic/claude-sonnet-4-20250514",
@@ -349,6 +547,32 @@ func executeTaskAI(task Task, apiKey string) (Result, error) {
 	// Удаляем возможные символы форматирования (точки, запятые в конце и т.д.)
 	resultStr = strings.Trim(resultStr, ".,!?;: \n\t\r")
 
+	if task.Precision == PrecisionInteger {
+		if strings.EqualFold(resultStr, "OVERFLOW") {
+			return Result{ID: task.ID, Overflow: true}, nil
+		}
+		result, err := strconv.ParseInt(resultStr, 10, 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("ошибка парсинга результата '%s': %v", resultStr, err)
+		}
+		return Result{ID: task.ID, Result: float64(result), IntResult: result}, nil
+	}
+	if task.Precision == PrecisionComplex {
+		result, err := parseComplex(resultStr)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{ID: task.ID, Result: real(result), Complex: &result}, nil
+	}
+	if task.Precision != "" {
+		exact, err := parseExact(resultStr, task.Precision)
+		if err != nil {
+			return Result{}, err
+		}
+		result, _ := exact.Float64()
+		return Result{ID: task.ID, Result: result, Exact: formatExact(exact, task.Precision)}, nil
+	}
+
 	// Парсим число из ответа
 	result, err := strconv.ParseFloat(resultStr, 64)
 	if err != nil {
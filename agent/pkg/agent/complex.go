@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"fmt"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// PrecisionComplex - режим комплексных чисел с плавающей точкой
+const PrecisionComplex = "complex"
+
+func executeComplex(task Task) (Result, error) {
+	result, err := complexOperation(task.Operation, task.ComplexArgs)
+	if err != nil {
+		return Result{}, err
+	}
+	if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+		return Result{}, fmt.Errorf("результат не является числом: %v", result)
+	}
+	return Result{ID: task.ID, Result: real(result), Complex: &result}, nil
+}
+
+func complexOperation(name string, args []complex128) (complex128, error) {
+	arity := map[string]int{"+": 2, "-": 2, "*": 2, "/": 2, "^": 2, "neg": 1, "==": 2, "!=": 2}
+	if count, found := arity[name]; found && len(args) != count {
+		return 0, fmt.Errorf("операция %s получает %d аргументов, а не %d", name, count, len(args))
+	}
+	if len(args) == 0 {
+		return 0, fmt.Errorf("неизвестная операция: %s", name)
+	}
+
+	switch name {
+	case "+":
+		return args[0] + args[1], nil
+	case "-":
+		return args[0] - args[1], nil
+	case "*":
+		return args[0] * args[1], nil
+	case "/":
+		if args[1] == 0 {
+			return 0, fmt.Errorf("деление на ноль")
+		}
+		return args[0] / args[1], nil
+	case "^":
+		return cmplx.Pow(args[0], args[1]), nil
+	case "neg":
+		return -args[0], nil
+	case "==", "!=":
+		// Комплексные числа можно сравнивать только на равенство.
+		return complex(float64(truth((args[0] == args[1]) == (name == "=="))), 0), nil
+	case "sqrt":
+		return cmplx.Sqrt(args[0]), nil
+	case "sin":
+		return cmplx.Sin(args[0]), nil
+	case "cos":
+		return cmplx.Cos(args[0]), nil
+	case "log":
+		if len(args) == 2 {
+			return cmplx.Log(args[0]) / cmplx.Log(args[1]), nil
+		}
+		return cmplx.Log(args[0]), nil
+	case "abs":
+		// Модуль комплексного числа - действительное число.
+		return complex(cmplx.Abs(args[0]), 0), nil
+	}
+	return 0, fmt.Errorf("операция %s недоступна в режиме complex", name)
+}
+
+// formatComplex записывает число без скобок и нулевых частей, например "11-2i".
+func formatComplex(value complex128) string {
+	if value == 0 {
+		return "0"
+	}
+	re := strconv.FormatFloat(real(value), 'g', -1, 64)
+	if imag(value) == 0 {
+		return re
+	}
+	im := strconv.FormatFloat(imag(value), 'g', -1, 64) + "i"
+	if real(value) == 0 {
+		return im
+	}
+	if imag(value) > 0 {
+		im = "+" + im
+	}
+	return re + im
+}
+
+// parseComplex разбирает запись комплексного числа, например "11-2i" или "(3+4i)"
+func parseComplex(text string) (complex128, error) {
+	value, err := strconv.ParseComplex(strings.ReplaceAll(text, " ", ""), 128)
+	if err != nil {
+		return 0, fmt.Errorf("неправильная запись комплексного числа: %q", text)
+	}
+	return value, nil
+}
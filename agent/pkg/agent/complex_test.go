@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComplexOperation(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []complex128
+		result    complex128
+	}{
+		{"+", []complex128{1 + 2i, 3 - 1i}, 4 + 1i},
+		{"-", []complex128{1 + 2i, 3 - 1i}, -2 + 3i},
+		{"*", []complex128{1 + 2i, 3 - 1i}, 5 + 5i},
+		{"/", []complex128{1 + 1i, 1i}, 1 - 1i},
+		{"neg", []complex128{1 - 2i}, -1 + 2i},
+		{"sqrt", []complex128{-4}, 2i},
+		{"abs", []complex128{3 + 4i}, 5},
+		{"log", []complex128{1}, 0},
+		{"==", []complex128{1 + 2i, 1 + 2i}, 1},
+		{"!=", []complex128{1 + 2i, 1 + 2i}, 0},
+	}
+	for _, c := range cases {
+		result, err := complexOperation(c.operation, c.args)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", c.operation, c.args, err)
+			continue
+		}
+		if result != c.result {
+			t.Errorf("%s %v: expected %v, got %v", c.operation, c.args, c.result, result)
+		}
+	}
+
+	for _, c := range []struct {
+		operation string
+		args      []complex128
+	}{
+		{"/", []complex128{1 + 1i, 0}},
+		{"<", []complex128{1, 2i}},
+		{"+", []complex128{1}},
+	} {
+		if _, err := complexOperation(c.operation, c.args); err == nil {
+			t.Errorf("%s %v: expected an error", c.operation, c.args)
+		}
+	}
+	if _, err := executeComplex(Task{Operation: "log", ComplexArgs: []complex128{0}}); err == nil {
+		t.Errorf("log(0): expected an error")
+	}
+}
+
+// Те же значения проверяет TestFormatComplex оркестратора: обе копии
+// formatComplex должны записывать числа одинаково.
+func TestFormatComplex(t *testing.T) {
+	cases := []struct {
+		value  complex128
+		result string
+	}{
+		{0, "0"},
+		{complex(math.Copysign(0, -1), 0), "0"},
+		{5, "5"},
+		{-2.5, "-2.5"},
+		{1i, "1i"},
+		{-1i, "-1i"},
+		{11 - 2i, "11-2i"},
+		{-1.5 + 0.25i, "-1.5+0.25i"},
+		{1e21 + 1e-7i, "1e+21+1e-07i"},
+	}
+	for _, c := range cases {
+		if result := formatComplex(c.value); result != c.result {
+			t.Errorf("%v: expected %s, got %s", c.value, c.result, result)
+		}
+	}
+}
+
+func TestParseComplex(t *testing.T) {
+	for text, expected := range map[string]complex128{
+		"1-2i":     1 - 2i,
+		"(3+4i)":   3 + 4i,
+		" 1 - 2i ": 1 - 2i,
+		"2i":       2i,
+		"-7":       -7,
+		"1e+21+1i": 1e21 + 1i,
+	} {
+		value, err := parseComplex(text)
+		if err != nil || value != expected {
+			t.Errorf("%q: expected %v, got %v, %v", text, expected, value, err)
+		}
+	}
+	for _, text := range []string{"1-2i", "11-2i", "1i", "-1.5+0.25i"} {
+		value, err := parseComplex(text)
+		if err != nil || formatComplex(value) != text {
+			t.Errorf("%q: round trip gave %q, %v", text, formatComplex(value), err)
+		}
+	}
+	if _, err := parseComplex("1-2j"); err == nil {
+		t.Errorf("1-2j: expected an error")
+	}
+}
@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PrecisionDecimal - десятичные дроби, например "0.25"
+	PrecisionDecimal = "decimal"
+	// PrecisionRational - обыкновенные дроби, например "1/3"
+	PrecisionRational = "rational"
+)
+
+// DecimalDigits - число знаков после запятой в режиме decimal.
+const DecimalDigits = 34
+
+// Большие и дробные степени считаются через math.Pow.
+const maxExactExponent = 1000
+
+// executeExact выполняет задачу точного режима над точными аргументами
+func executeExact(task Task) (Result, error) {
+	args := make([]*big.Rat, len(task.ExactArgs))
+	for i, text := range task.ExactArgs {
+		arg, err := parseExact(text, task.Precision)
+		if err != nil {
+			return Result{}, err
+		}
+		args[i] = arg
+	}
+
+	result, err := exactOperation(task.Operation, args)
+	if err != nil {
+		return Result{}, err
+	}
+	approx, _ := result.Float64()
+	return Result{ID: task.ID, Result: approx, Exact: formatExact(result, task.Precision)}, nil
+}
+
+func exactOperation(name string, args []*big.Rat) (*big.Rat, error) {
+	arity := map[string]int{"+": 2, "-": 2, "*": 2, "/": 2, "//": 2, "%": 2, "^": 2, "neg": 1,
+		"==": 2, "!=": 2, "<": 2, "<=": 2, ">": 2, ">=": 2}
+	if count, found := arity[name]; found && len(args) != count {
+		return nil, fmt.Errorf("операция %s получает %d аргументов, а не %d", name, count, len(args))
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("неизвестная операция: %s", name)
+	}
+
+	result := new(big.Rat)
+	switch name {
+	case "+":
+		return result.Add(args[0], args[1]), nil
+	case "-":
+		return result.Sub(args[0], args[1]), nil
+	case "*":
+		return result.Mul(args[0], args[1]), nil
+	case "/", "//", "%":
+		if args[1].Sign() == 0 {
+			return nil, fmt.Errorf("деление на ноль")
+		}
+		result.Quo(args[0], args[1])
+		switch name {
+		case "//":
+			// Знаменатель big.Rat положителен, поэтому Div округляет вниз.
+			return result.SetInt(new(big.Int).Div(result.Num(), result.Denom())), nil
+		case "%":
+			// Остаток со знаком делимого, как у math.Mod: a - trunc(a/b)*b.
+			quotient := new(big.Rat).SetInt(new(big.Int).Quo(result.Num(), result.Denom()))
+			return result.Sub(args[0], quotient.Mul(quotient, args[1])), nil
+		}
+		return result, nil
+	case "^":
+		return exactPow(args[0], args[1])
+	case "neg":
+		return result.Neg(args[0]), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return result.SetInt64(truth(comparisons[name](args[0].Cmp(args[1])))), nil
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return nil, fmt.Errorf("корень из отрицательного числа")
+		}
+		if root, ok := exactSqrt(args[0]); ok {
+			return root, nil
+		}
+		root := new(big.Float).SetPrec(256).SetRat(args[0])
+		root.Sqrt(root)
+		root.Rat(result)
+		return roundDecimal(result), nil
+	case "abs":
+		return result.Abs(args[0]), nil
+	case "min", "max":
+		result.Set(args[0])
+		for _, arg := range args[1:] {
+			if (name == "min" && arg.Cmp(result) < 0) || (name == "max" && arg.Cmp(result) > 0) {
+				result.Set(arg)
+			}
+		}
+		return result, nil
+	case "round":
+		// Половина округляется от нуля, как у math.Round.
+		result.Abs(args[0])
+		result.Add(result, big.NewRat(1, 2))
+		result.SetInt(new(big.Int).Div(result.Num(), result.Denom()))
+		if args[0].Sign() < 0 {
+			result.Neg(result)
+		}
+		return result, nil
+	}
+
+	// Остальные функции вычисляются приближенно, как в обычном режиме.
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		values[i], _ = arg.Float64()
+	}
+	value, err := executeFunction(name, values)
+	if err != nil {
+		return nil, err
+	}
+	return decimalFromFloat(value)
+}
+
+func exactSqrt(rat *big.Rat) (*big.Rat, bool) {
+	num := new(big.Int).Sqrt(rat.Num())
+	denom := new(big.Int).Sqrt(rat.Denom())
+	if new(big.Int).Mul(num, num).Cmp(rat.Num()) != 0 || new(big.Int).Mul(denom, denom).Cmp(rat.Denom()) != 0 {
+		return nil, false
+	}
+	return new(big.Rat).SetFrac(num, denom), true
+}
+
+func exactPow(base *big.Rat, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() || !exponent.Num().IsInt64() || abs64(exponent.Num().Int64()) > maxExactExponent {
+		b, _ := base.Float64()
+		e, _ := exponent.Float64()
+		return decimalFromFloat(math.Pow(b, e))
+	}
+	n := exponent.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, fmt.Errorf("деление на ноль")
+	}
+	num := new(big.Int).Exp(base.Num(), big.NewInt(abs64(n)), nil)
+	denom := new(big.Int).Exp(base.Denom(), big.NewInt(abs64(n)), nil)
+	if n < 0 {
+		num, denom = denom, num
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func decimalFromFloat(value float64) (*big.Rat, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("результат не является числом: %v", value)
+	}
+	return parseDecimal(strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// parseDecimal разбирает десятичную запись числа, например "-12.5" или "1e-7"
+func parseDecimal(text string) (*big.Rat, error) {
+	return parseExact(text, PrecisionDecimal)
+}
+
+func parseExact(text string, precision string) (*big.Rat, error) {
+	rat, ok := new(big.Rat).SetString(text)
+	if !ok || (precision != PrecisionRational && strings.Contains(text, "/")) {
+		return nil, fmt.Errorf("неправильная запись числа: %q", text)
+	}
+	return rat, nil
+}
+
+func formatExact(rat *big.Rat, precision string) string {
+	if precision == PrecisionRational {
+		return rat.RatString()
+	}
+	return formatDecimal(rat)
+}
+
+// roundDecimal округляет дробь до DecimalDigits знаков после запятой
+func roundDecimal(rat *big.Rat) *big.Rat {
+	rounded, _ := new(big.Rat).SetString(rat.FloatString(DecimalDigits))
+	return rounded
+}
+
+// formatDecimal записывает дробь без лишних нулей, округляя до DecimalDigits знаков.
+func formatDecimal(rat *big.Rat) string {
+	if rat.IsInt() {
+		return rat.Num().String()
+	}
+	text := rat.FloatString(decimalPlaces(rat))
+	text = strings.TrimRight(text, "0")
+	text = strings.TrimSuffix(text, ".")
+	if text == "-0" {
+		return "0"
+	}
+	return text
+}
+
+func decimalPlaces(rat *big.Rat) int {
+	denominator := new(big.Int).Set(rat.Denom())
+	places := 0
+	for _, factor := range []int64{2, 5} {
+		count := 0
+		divisor := big.NewInt(factor)
+		for {
+			quotient, remainder := new(big.Int).QuoRem(denominator, divisor, new(big.Int))
+			if remainder.Sign() != 0 {
+				break
+			}
+			denominator = quotient
+			count++
+		}
+		places = max(places, count)
+	}
+	if denominator.Cmp(big.NewInt(1)) != 0 {
+		return DecimalDigits
+	}
+	return places
+}
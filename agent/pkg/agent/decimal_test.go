@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// Те же значения проверяет TestFormatDecimal оркестратора: обе копии
+// formatDecimal должны записывать дроби одинаково.
+func TestFormatDecimal(t *testing.T) {
+	cases := []struct {
+		rat    string
+		result string
+	}{
+		{"5", "5"},
+		{"-7/2", "-3.5"},
+		{"3/10", "0.3"},
+		{"1/1024", "0.0009765625"},
+		{"1/100000000000000000000000000000000000000000", "0.00000000000000000000000000000000000000001"},
+		{"1/3", "0.3333333333333333333333333333333333"},
+		{"2/3", "0.6666666666666666666666666666666667"},
+		{"-1/7", "-0.1428571428571428571428571428571429"},
+		{"1/6", "0.1666666666666666666666666666666667"},
+		{"-1/300000000000000000000000000000000000000", "0"},
+		{"1/2999999999999999999999999999999999", "0.0000000000000000000000000000000003"},
+	}
+	for _, c := range cases {
+		rat, _ := new(big.Rat).SetString(c.rat)
+		if result := formatDecimal(rat); result != c.result {
+			t.Errorf("%s: expected %s, got %s", c.rat, c.result, result)
+		}
+	}
+}
+
+func TestExecuteExact(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []string
+		result    string
+	}{
+		{"/", []string{"1", "3"}, "0.3333333333333333333333333333333333"},
+		{"+", []string{"0.1", "0.2"}, "0.3"},
+		{"^", []string{"2", "-2"}, "0.25"},
+		{"^", []string{"-0.5", "3"}, "-0.125"},
+		{"^", []string{"10", "1000"}, "1" + strings.Repeat("0", 1000)},
+		{"^", []string{"2", "0.5"}, "1.4142135623730951"},
+		{"^", []string{"1.0001", "1001"}, "1.1052759091424573"},
+		{"sqrt", []string{"2.25"}, "1.5"},
+		{"sqrt", []string{"2"}, "1.4142135623730950488016887242096981"},
+		{"//", []string{"-7", "2"}, "-4"},
+		{"%", []string{"-7", "2"}, "-1"},
+		{"round", []string{"-2.5"}, "-3"},
+	}
+	for _, c := range cases {
+		result, err := executeExact(Task{Operation: c.operation, ExactArgs: c.args, Precision: PrecisionDecimal})
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", c.operation, c.args, err)
+			continue
+		}
+		if result.Exact != c.result {
+			t.Errorf("%s %v: expected %s, got %s", c.operation, c.args, c.result, result.Exact)
+		}
+	}
+}
+
+func TestExecuteExactErrors(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []string
+	}{
+		{"/", []string{"1", "0"}},
+		{"^", []string{"0", "-1"}},
+		{"^", []string{"-8", "0.5"}},
+		{"^", []string{"10", "2000"}},
+		{"sqrt", []string{"-2"}},
+		{"+", []string{"1/3", "1"}},
+	}
+	for _, c := range cases {
+		if _, err := executeExact(Task{Operation: c.operation, ExactArgs: c.args, Precision: PrecisionDecimal}); err == nil {
+			t.Errorf("%s %v: expected an error", c.operation, c.args)
+		}
+	}
+}
+
+func TestDecimalFromFloat(t *testing.T) {
+	rat, err := decimalFromFloat(0.1)
+	if err != nil || rat.Cmp(big.NewRat(1, 10)) != 0 {
+		t.Errorf("expected 1/10, got %v, %v", rat, err)
+	}
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := decimalFromFloat(value); err == nil {
+			t.Errorf("%v: expected an error", value)
+		}
+	}
+}
+
+func TestExecuteRational(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []string
+		result    string
+	}{
+		{"/", []string{"1", "3"}, "1/3"},
+		{"+", []string{"1/3", "1/6"}, "1/2"},
+		{"*", []string{"-2/4", "3"}, "-3/2"},
+		{"/", []string{"6", "3"}, "2"},
+		{"^", []string{"2/3", "-2"}, "9/4"},
+		{"sqrt", []string{"4/9"}, "2/3"},
+		{"//", []string{"-7/2", "1"}, "-4"},
+		{"%", []string{"7/2", "-1"}, "1/2"},
+		{"+", []string{"0.1", "1/5"}, "3/10"},
+	}
+	for _, c := range cases {
+		result, err := executeExact(Task{Operation: c.operation, ExactArgs: c.args, Precision: PrecisionRational})
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", c.operation, c.args, err)
+			continue
+		}
+		if result.Exact != c.result {
+			t.Errorf("%s %v: expected %s, got %s", c.operation, c.args, c.result, result.Exact)
+		}
+	}
+
+	for _, operation := range []string{"/", "//", "%"} {
+		if _, err := exactOperation(operation, []*big.Rat{big.NewRat(1, 3), new(big.Rat)}); err == nil {
+			t.Errorf("1/3 %s 0: expected an error", operation)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// PrecisionInteger - режим 64-битных целых чисел со знаком
+const PrecisionInteger = "integer"
+
+var overflowed = new(big.Int).Lsh(big.NewInt(1), 64)
+
+// Вычисления идут в big.Int, а результат вне int64 возвращается с Overflow.
+func executeInteger(task Task) (Result, error) {
+	args := make([]*big.Int, len(task.IntArgs))
+	for i, arg := range task.IntArgs {
+		args[i] = big.NewInt(arg)
+	}
+
+	result, err := integerOperation(task.Operation, args)
+	if err != nil {
+		return Result{}, err
+	}
+	if !result.IsInt64() {
+		return Result{ID: task.ID, Overflow: true}, nil
+	}
+	value := result.Int64()
+	return Result{ID: task.ID, Result: float64(value), IntResult: value}, nil
+}
+
+func integerOperation(name string, args []*big.Int) (*big.Int, error) {
+	arity := map[string]int{"+": 2, "-": 2, "*": 2, "/": 2, "//": 2, "%": 2, "^": 2, "&": 2, "|": 2, "<<": 2, ">>": 2, "neg": 1, "~": 1,
+		"==": 2, "!=": 2, "<": 2, "<=": 2, ">": 2, ">=": 2}
+	if count, found := arity[name]; found && len(args) != count {
+		return nil, fmt.Errorf("операция %s получает %d аргументов, а не %d", name, count, len(args))
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("неизвестная операция: %s", name)
+	}
+
+	result := new(big.Int)
+	switch name {
+	case "+":
+		return result.Add(args[0], args[1]), nil
+	case "-":
+		return result.Sub(args[0], args[1]), nil
+	case "*":
+		return result.Mul(args[0], args[1]), nil
+	case "/", "//", "%":
+		if args[1].Sign() == 0 {
+			return nil, fmt.Errorf("деление на ноль")
+		}
+		remainder := new(big.Int)
+		// Quo и Rem отбрасывают дробную часть, как в Go: -7 / 2 = -3.
+		result.QuoRem(args[0], args[1], remainder)
+		switch name {
+		case "//":
+			if remainder.Sign() != 0 && remainder.Sign() != args[1].Sign() {
+				result.Sub(result, big.NewInt(1))
+			}
+		case "%":
+			return remainder, nil
+		}
+		return result, nil
+	case "^":
+		if args[1].Sign() < 0 {
+			return nil, fmt.Errorf("отрицательный показатель степени")
+		}
+		if args[1].Cmp(big.NewInt(64)) >= 0 && args[0].CmpAbs(big.NewInt(1)) > 0 {
+			return overflowed, nil
+		}
+		return result.Exp(args[0], args[1], nil), nil
+	case "neg":
+		return result.Neg(args[0]), nil
+	case "~":
+		return result.Not(args[0]), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return result.SetInt64(truth(comparisons[name](args[0].Cmp(args[1])))), nil
+	case "&":
+		return result.And(args[0], args[1]), nil
+	case "|":
+		return result.Or(args[0], args[1]), nil
+	case "<<", ">>":
+		if args[1].Sign() < 0 {
+			return nil, fmt.Errorf("сдвиг на отрицательное число битов")
+		}
+		count := uint(min(args[1].Int64(), 64))
+		if name == ">>" {
+			// Арифметический сдвиг: знак числа сохраняется.
+			return result.Rsh(args[0], count), nil
+		}
+		if count == 64 && args[0].Sign() != 0 {
+			return overflowed, nil
+		}
+		return result.Lsh(args[0], count), nil
+	case "abs":
+		return result.Abs(args[0]), nil
+	case "min", "max":
+		result.Set(args[0])
+		for _, arg := range args[1:] {
+			if (name == "min" && arg.Cmp(result) < 0) || (name == "max" && arg.Cmp(result) > 0) {
+				result.Set(arg)
+			}
+		}
+		return result, nil
+	case "round":
+		return result.Set(args[0]), nil
+	}
+	return nil, fmt.Errorf("операция %s недоступна в режиме integer", name)
+}
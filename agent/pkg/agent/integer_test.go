@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExecuteInteger(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []int64
+		result    int64
+		overflow  bool
+	}{
+		{"+", []int64{math.MaxInt64, 1}, 0, true},
+		{"-", []int64{math.MinInt64, 1}, 0, true},
+		{"*", []int64{math.MaxInt64, 2}, 0, true},
+		{"*", []int64{-3, 4}, -12, false},
+		{"<<", []int64{1, 62}, 1 << 62, false},
+		{"<<", []int64{1, 63}, 0, true},
+		{"<<", []int64{1, 64}, 0, true},
+		{"<<", []int64{1, 1000}, 0, true},
+		{"<<", []int64{0, 1000}, 0, false},
+		{"^", []int64{2, 62}, 1 << 62, false},
+		{"^", []int64{2, 64}, 0, true},
+		{"^", []int64{-1, math.MaxInt64}, -1, false},
+		{"^", []int64{1, 1 << 40}, 1, false},
+		{"/", []int64{-7, 2}, -3, false},
+		{"//", []int64{-7, 2}, -4, false},
+		{"//", []int64{7, -2}, -4, false},
+		{"//", []int64{-8, 2}, -4, false},
+		{"%", []int64{-7, 2}, -1, false},
+		{"%", []int64{7, -2}, 1, false},
+		{">>", []int64{-7, 1}, -4, false},
+		{">>", []int64{7, 1}, 3, false},
+		{">>", []int64{-1, 1000}, -1, false},
+		{"//", []int64{math.MinInt64, -1}, 0, true},
+		{"/", []int64{math.MinInt64, -1}, 0, true},
+		{"%", []int64{math.MinInt64, -1}, 0, false},
+	}
+	for _, c := range cases {
+		result, err := executeInteger(Task{Operation: c.operation, IntArgs: c.args})
+		if err != nil {
+			t.Errorf("%d %s %d: unexpected error %v", c.args[0], c.operation, c.args[1], err)
+			continue
+		}
+		if result.Overflow != c.overflow || result.IntResult != c.result {
+			t.Errorf("%d %s %d: expected %d (overflow %v), got %d (overflow %v)",
+				c.args[0], c.operation, c.args[1], c.result, c.overflow, result.IntResult, result.Overflow)
+		}
+	}
+}
+
+func TestExecuteIntegerErrors(t *testing.T) {
+	cases := []struct {
+		operation string
+		args      []int64
+	}{
+		{"/", []int64{1, 0}},
+		{"//", []int64{1, 0}},
+		{"%", []int64{1, 0}},
+		{"^", []int64{2, -1}},
+		{"<<", []int64{1, -1}},
+		{">>", []int64{1, -1}},
+		{"+", []int64{1}},
+		{"sqrt", []int64{4}},
+	}
+	for _, c := range cases {
+		if _, err := executeInteger(Task{Operation: c.operation, IntArgs: c.args}); err == nil {
+			t.Errorf("%s %v: expected an error", c.operation, c.args)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package agent
+
+var comparisons = map[string]func(cmp int) bool{
+	"==": func(cmp int) bool { return cmp == 0 },
+	"!=": func(cmp int) bool { return cmp != 0 },
+	"<":  func(cmp int) bool { return cmp < 0 },
+	"<=": func(cmp int) bool { return cmp <= 0 },
+	">":  func(cmp int) bool { return cmp > 0 },
+	">=": func(cmp int) bool { return cmp >= 0 },
+}
+
+// truth переводит логическое значение в число 1 или 0
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -94,12 +94,24 @@ func (*EmptyResponse) Descriptor() ([]byte, []int) {
 }
 
 type Task struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	Arg1          float32                `protobuf:"fixed32,2,opt,name=arg1,proto3" json:"arg1,omitempty"`
-	Arg2          float32                `protobuf:"fixed32,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
-	Operation     string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
-	OperationTime int32                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Arg1  float32                `protobuf:"fixed32,2,opt,name=arg1,proto3" json:"arg1,omitempty"`
+	Arg2  float32                `protobuf:"fixed32,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
+	// Операция или функция. Сравнения ==, !=, <, <=, >, >= и логическое
+	// отрицание ! возвращают в result 1 (истина) или 0 (ложь)
+	Operation     string `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
+	OperationTime int32  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
+	// Аргументы функции, например sqrt или max
+	Args []float64 `protobuf:"fixed64,6,rep,packed,name=args,proto3" json:"args,omitempty"`
+	// Режим вычисления, например decimal. Пустая строка означает float
+	Precision string `protobuf:"bytes,7,opt,name=precision,proto3" json:"precision,omitempty"`
+	// Точная запись всех аргументов операции, если задан precision
+	ExactArgs []string `protobuf:"bytes,8,rep,name=exact_args,json=exactArgs,proto3" json:"exact_args,omitempty"`
+	// Все аргументы операции в режиме integer
+	IntArgs []int64 `protobuf:"varint,9,rep,packed,name=int_args,json=intArgs,proto3" json:"int_args,omitempty"`
+	// Все аргументы операции в режиме complex
+	ComplexArgs   []*Complex `protobuf:"bytes,10,rep,name=complex_args,json=complexArgs,proto3" json:"complex_args,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -169,10 +181,61 @@ func (x *Task) GetOperationTime() int32 {
 	return 0
 }
 
+func (x *Task) GetArgs() []float64 {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *Task) GetPrecision() string {
+	if x != nil {
+		return x.Precision
+	}
+	return ""
+}
+
+func (x *Task) GetExactArgs() []string {
+	if x != nil {
+		return x.ExactArgs
+	}
+	return nil
+}
+
+func (x *Task) GetIntArgs() []int64 {
+	if x != nil {
+		return x.IntArgs
+	}
+	return nil
+}
+
+func (x *Task) GetComplexArgs() []*Complex {
+	if x != nil {
+		return x.ComplexArgs
+	}
+	return nil
+}
+
 type TaskResult struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	Result        float32                `protobuf:"fixed32,2,opt,name=result,proto3" json:"result,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Результат, а для сравнений и ! - 1 или 0
+	Result float32 `protobuf:"fixed32,2,opt,name=result,proto3" json:"result,omitempty"`
+	// Точная запись результата, если задан precision
+	ExactResult string `protobuf:"bytes,3,opt,name=exact_result,json=exactResult,proto3" json:"exact_result,omitempty"`
+	// Результат в режиме integer
+	IntResult int64 `protobuf:"varint,4,opt,name=int_result,json=intResult,proto3" json:"int_result,omitempty"`
+	// Результат не помещается в 64-битное целое
+	Overflow bool `protobuf:"varint,5,opt,name=overflow,proto3" json:"overflow,omitempty"`
+	// Результат в режиме complex
+	ComplexResult *Complex `protobuf:"bytes,6,opt,name=complex_result,json=complexResult,proto3" json:"complex_result,omitempty"`
+	// Имя агента, который выполнил задачу
+	Agent string `protobuf:"bytes,7,opt,name=agent,proto3" json:"agent,omitempty"`
+	// Результат получен от нейросети, его нельзя кэшировать
+	Ai bool `protobuf:"varint,8,opt,name=ai,proto3" json:"ai,omitempty"`
+	// Причина, по которой агент не выполнил задачу, например деление на ноль.
+	// Если она указана, результат не используется
+	Error         string `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -221,6 +284,110 @@ func (x *TaskResult) GetResult() float32 {
 	return 0
 }
 
+func (x *TaskResult) GetExactResult() string {
+	if x != nil {
+		return x.ExactResult
+	}
+	return ""
+}
+
+func (x *TaskResult) GetIntResult() int64 {
+	if x != nil {
+		return x.IntResult
+	}
+	return 0
+}
+
+func (x *TaskResult) GetOverflow() bool {
+	if x != nil {
+		return x.Overflow
+	}
+	return false
+}
+
+func (x *TaskResult) GetComplexResult() *Complex {
+	if x != nil {
+		return x.ComplexResult
+	}
+	return nil
+}
+
+func (x *TaskResult) GetAgent() string {
+	if x != nil {
+		return x.Agent
+	}
+	return ""
+}
+
+func (x *TaskResult) GetAi() bool {
+	if x != nil {
+		return x.Ai
+	}
+	return false
+}
+
+func (x *TaskResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Комплексное число
+type Complex struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Действительная часть
+	Real float64 `protobuf:"fixed64,1,opt,name=real,proto3" json:"real,omitempty"`
+	// Мнимая часть
+	Imag          float64 `protobuf:"fixed64,2,opt,name=imag,proto3" json:"imag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Complex) Reset() {
+	*x = Complex{}
+	mi := &file_proto_calc_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Complex) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Complex) ProtoMessage() {}
+
+func (x *Complex) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_calc_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Complex.ProtoReflect.Descriptor instead.
+func (*Complex) Descriptor() ([]byte, []int) {
+	return file_proto_calc_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Complex) GetReal() float64 {
+	if x != nil {
+		return x.Real
+	}
+	return 0
+}
+
+func (x *Complex) GetImag() float64 {
+	if x != nil {
+		return x.Imag
+	}
+	return 0
+}
+
 var File_proto_calc_proto protoreflect.FileDescriptor
 
 const file_proto_calc_proto_rawDesc = "" +
@@ -228,17 +395,35 @@ const file_proto_calc_proto_rawDesc = "" +
 	"\x10proto/calc.proto\x12\n" +
 	"calc_proto\"\x0e\n" +
 	"\fEmptyRequest\"\x0f\n" +
-	"\rEmptyResponse\"\x83\x01\n" +
+	"\rEmptyResponse\"\xa7\x02\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x05R\x02id\x12\x12\n" +
 	"\x04arg1\x18\x02 \x01(\x02R\x04arg1\x12\x12\n" +
 	"\x04arg2\x18\x03 \x01(\x02R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
-	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\"4\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\x12\x12\n" +
+	"\x04args\x18\x06 \x03(\x01R\x04args\x12\x1c\n" +
+	"\tprecision\x18\a \x01(\tR\tprecision\x12\x1d\n" +
+	"\n" +
+	"exact_args\x18\b \x03(\tR\texactArgs\x12\x19\n" +
+	"\bint_args\x18\t \x03(\x03R\aintArgs\x126\n" +
+	"\fcomplex_args\x18\n" +
+	" \x03(\v2\x13.calc_proto.ComplexR\vcomplexArgs\"\x8a\x02\n" +
 	"\n" +
 	"TaskResult\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x05R\x02id\x12\x16\n" +
-	"\x06result\x18\x02 \x01(\x02R\x06result2\x8e\x01\n" +
+	"\x06result\x18\x02 \x01(\x02R\x06result\x12!\n" +
+	"\fexact_result\x18\x03 \x01(\tR\vexactResult\x12\x1d\n" +
+	"\n" +
+	"int_result\x18\x04 \x01(\x03R\tintResult\x12\x1a\n" +
+	"\boverflow\x18\x05 \x01(\bR\boverflow\x12:\n" +
+	"\x0ecomplex_result\x18\x06 \x01(\v2\x13.calc_proto.ComplexR\rcomplexResult\x12\x14\n" +
+	"\x05agent\x18\a \x01(\tR\x05agent\x12\x0e\n" +
+	"\x02ai\x18\b \x01(\bR\x02ai\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\"1\n" +
+	"\aComplex\x12\x12\n" +
+	"\x04real\x18\x01 \x01(\x01R\x04real\x12\x12\n" +
+	"\x04imag\x18\x02 \x01(\x01R\x04imag2\x8e\x01\n" +
 	"\x11CalculatorService\x127\n" +
 	"\aGetTask\x12\x18.calc_proto.EmptyRequest\x1a\x10.calc_proto.Task\"\x00\x12@\n" +
 	"\tGetResult\x12\x16.calc_proto.TaskResult\x1a\x19.calc_proto.EmptyResponse\"\x00B?Z=github.com/veronicashkarova/server-for-calc/orkestrator/protob\x06proto3"
@@ -255,23 +440,26 @@ func file_proto_calc_proto_rawDescGZIP() []byte {
 	return file_proto_calc_proto_rawDescData
 }
 
-var file_proto_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_proto_calc_proto_goTypes = []any{
 	(*EmptyRequest)(nil),  // 0: calc_proto.EmptyRequest
 	(*EmptyResponse)(nil), // 1: calc_proto.EmptyResponse
 	(*Task)(nil),          // 2: calc_proto.Task
 	(*TaskResult)(nil),    // 3: calc_proto.TaskResult
+	(*Complex)(nil),       // 4: calc_proto.Complex
 }
 var file_proto_calc_proto_depIdxs = []int32{
-	0, // 0: calc_proto.CalculatorService.GetTask:input_type -> calc_proto.EmptyRequest
-	3, // 1: calc_proto.CalculatorService.GetResult:input_type -> calc_proto.TaskResult
-	2, // 2: calc_proto.CalculatorService.GetTask:output_type -> calc_proto.Task
-	1, // 3: calc_proto.CalculatorService.GetResult:output_type -> calc_proto.EmptyResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4, // 0: calc_proto.Task.complex_args:type_name -> calc_proto.Complex
+	4, // 1: calc_proto.TaskResult.complex_result:type_name -> calc_proto.Complex
+	0, // 2: calc_proto.CalculatorService.GetTask:input_type -> calc_proto.EmptyRequest
+	3, // 3: calc_proto.CalculatorService.GetResult:input_type -> calc_proto.TaskResult
+	2, // 4: calc_proto.CalculatorService.GetTask:output_type -> calc_proto.Task
+	1, // 5: calc_proto.CalculatorService.GetResult:output_type -> calc_proto.EmptyResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_calc_proto_init() }
@@ -285,7 +473,7 @@ func file_proto_calc_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_calc_proto_rawDesc), len(file_proto_calc_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -7,6 +7,7 @@ import (
 func main() {
 	app := application.New()
 	app.CreareDataBase()
+	app.ResumeExpressions()
 	app.RunServer()
 	
 }
\ No newline at end of file
@@ -9,10 +9,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/veronicashkarova/server-for-calc/pkg/calc"
 	"github.com/veronicashkarova/server-for-calc/pkg/contract"
-	"github.com/veronicashkarova/server-for-calc/pkg/db"
 	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
 )
 
@@ -78,7 +78,7 @@ func AutorizationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func NewExpressionHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) NewExpressionHandler(w http.ResponseWriter, r *http.Request) {
 	request := new(Request)
 	defer r.Body.Close()
 	err := json.NewDecoder(r.Body).Decode(&request)
@@ -87,47 +87,154 @@ func NewExpressionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	deadline, err := request.deadline(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	userLogin := r.Context().Value("user_login").(string)
-	result, id, err := orkestrator.AddExpression(userLogin, request.Expression)
+	result, id, err := a.orkestrator.AddExpression(userLogin, request.Expression, request.Precision, request.Optimize, request.Cache, deadline)
 
 	if err != nil {
-		switch {
-		case errors.Is(err, calc.ErrInvalidExpression):
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case errors.Is(err, calc.ErrEmptyExpression):
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-		}
+		writeAddError(w, err)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, result)
+
+		go a.orkestrator.CalculateExpression(id, request.Expression, nil)
+	}
+}
+
+// NewScriptHandler принимает сценарий: POST /api/v1/scripts.
+func (a *Application) NewScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	request := new(Request)
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deadline, err := request.deadline(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userLogin := r.Context().Value("user_login").(string)
+	result, id, err := a.orkestrator.AddScript(userLogin, request.Script, request.Precision, request.Optimize, request.Cache, deadline)
+
+	if err != nil {
+		writeAddError(w, err)
 	} else {
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprint(w, result)
 
-		go func() {
-			fmt.Printf("NewExpressionHandler: запуск calc.Calc для выражения %s с ID=%s\n", request.Expression, id)
-			result, err := calc.Calc(request.Expression, id, contract.TaskChannel)
-			fmt.Printf("NewExpressionHandler: calc.Calc завершился для ID=%s, result=%f, err=%v\n", id, result, err)
-			value, exist := contract.ExpressionMap[id]
-			if exist {
-				if err != nil {
-					fmt.Printf("NewExpressionHandler: ошибка вычисления для ID=%s: %v\n", id, err)
-					value.Data.Status = err.Error()
-				} else {
-					fmt.Printf("NewExpressionHandler: вычисление успешно для ID=%s, результат=%f\n", id, result)
-					value.Data.Status = contract.Done
-					value.Data.Result = strconv.FormatFloat(result, 'f', 3, 64)
-				}
-				contract.ExpressionMap[id] = value
-
-				intId, err := strconv.ParseInt(id, 10, 64)
-				if err == nil {
-					db.UpdateExpressionStatusResult(intId, contract.Done, strconv.FormatFloat(result, 'f', 3, 64))
-				}
-			} else {
-				fmt.Printf("NewExpressionHandler: выражение ID=%s не найдено в ExpressionMap\n", id)
-			}
-		}()
+		go a.orkestrator.CalculateExpression(id, request.Script, nil)
+	}
+}
+
+func writeAddError(w http.ResponseWriter, err error) {
+	var parseErr *calc.ParseError
+	switch {
+	case errors.Is(err, calc.ErrEmptyExpression):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	case errors.As(err, &parseErr), errors.Is(err, calc.ErrInvalidDeadline), errors.Is(err, calc.ErrInvalidPrecision):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
 	}
 }
 
+type ValidateResponse struct {
+	Valid bool `json:"valid"`
+	*calc.Plan
+	*ErrorResponse
+}
+
+// ValidateHandler проверяет выражение без вычисления: POST /api/v1/validate.
+func (a *Application) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	request := new(Request)
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userLogin := r.Context().Value("user_login").(string)
+	var response ValidateResponse
+	plan, err := a.orkestrator.ValidateExpression(userLogin, request.Expression, request.Precision, request.Optimize)
+	if err != nil {
+		errorResponse := newErrorResponse(err)
+		response.ErrorResponse = &errorResponse
+	} else {
+		response.Valid = true
+		response.Plan = &plan
+	}
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(jsonBytes))
+}
+
+// CacheHandler отвечает на GET /api/v1/cache.
+func CacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	jsonBytes, err := json.Marshal(calc.Cache.Stats())
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(jsonBytes))
+}
+
+// ErrorResponse - ошибка в ответе API; для синтаксической ошибки указано ее место.
+type ErrorResponse struct {
+	ErrorCode    string    `json:"error_code"`
+	ErrorMessage string    `json:"error_message"`
+	Position     *calc.Pos `json:"position,omitempty"`
+	Token        string    `json:"token,omitempty"`
+	Snippet      string    `json:"snippet,omitempty"`
+}
+
+func newErrorResponse(err error) ErrorResponse {
+	response := ErrorResponse{ErrorCode: calc.ErrorCode(err), ErrorMessage: err.Error()}
+	var parseErr *calc.ParseError
+	if errors.As(err, &parseErr) {
+		response.Position = &parseErr.Pos
+		response.Token = parseErr.Token
+		response.Snippet = parseErr.Snippet()
+	}
+	return response
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	jsonBytes, err := json.Marshal(newErrorResponse(err))
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jsonBytes))
+}
+
 func ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	userLogin := r.Context().Value("user_login").(string)
@@ -145,16 +252,102 @@ func ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func IdHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) IdHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/trace") {
+		a.traceExpression(w, r)
+		return
+	}
+
 	id, err := isIdExpressionRequest(r.URL)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		a.cancelExpression(w, r, id)
+		return
+	}
+
+	wait, err := waitTimeout(r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	userLogin := r.Context().Value("user_login").(string)
-	result, err := orkestrator.GetExpressionForId(userLogin, id)
+	var result string
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		result, err = a.orkestrator.WaitExpression(ctx, userLogin, id)
+	} else {
+		result, err = a.orkestrator.GetExpressionForId(userLogin, id)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, result)
+}
+
+const maxWaitMs = 60000
+
+func waitTimeout(url *url.URL) (time.Duration, error) {
+	text := url.Query().Get("wait_ms")
+	if text == "" {
+		return 0, nil
+	}
+	waitMs, err := strconv.Atoi(text)
+	if err != nil || waitMs < 0 {
+		return 0, fmt.Errorf("неправильное значение wait_ms: %q", text)
+	}
+	return time.Duration(min(waitMs, maxWaitMs)) * time.Millisecond, nil
+}
+
+// traceExpression отвечает на GET /api/v1/expressions/{id}/trace.
+func (a *Application) traceExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	url := *r.URL
+	url.Path = strings.TrimSuffix(url.Path, "/trace")
+	id, err := isIdExpressionRequest(&url)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userLogin := r.Context().Value("user_login").(string)
+	result, err := a.orkestrator.ExpressionTrace(userLogin, id)
+	if err != nil {
+		if errors.Is(err, calc.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, result)
+}
+
+func (a *Application) cancelExpression(w http.ResponseWriter, r *http.Request, id string) {
+	userLogin := r.Context().Value("user_login").(string)
+	result, err := a.orkestrator.CancelExpression(userLogin, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, calc.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, calc.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, result)
@@ -167,7 +360,6 @@ func isIdExpressionRequest(url *url.URL) (string, error) {
 
 	// Получаем последний сегмент
 	lastSegment := pathSegments[len(pathSegments)-1]
-	fmt.Println(lastSegment)
 
 	return lastSegment, nil
 }
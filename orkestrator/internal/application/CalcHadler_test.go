@@ -14,16 +14,18 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
 )
 
 // Run this test independantly 
 func TestExpressionsHandler(t *testing.T) {
-	contract.ExpressionMap = map[string]contract.ExpressionMapData{}
+	app := &Application{orkestrator: orkestrator.New(orkestrator.NewRegistry())}
 	jsonRequest := `{"expression": "3+(8*3)"}`
 	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewBufferString(jsonRequest))
 	w := httptest.NewRecorder()
-	NewExpressionHandler(w, req)
+	app.NewExpressionHandler(w, req)
 	req = httptest.NewRequest(http.MethodGet, "/", nil)
 	w = httptest.NewRecorder()
 	ExpressionsHandler(w,req)
@@ -48,11 +50,11 @@ func TestExpressionsHandler(t *testing.T) {
 // Run this test independantly
 func TestNewExpressionHandler (t *testing.T) {
 
-	contract.ExpressionMap = map[string]contract.ExpressionMapData{}
+	app := &Application{orkestrator: orkestrator.New(orkestrator.NewRegistry())}
 	jsonRequest := `{"expression": "3+(8*3)"}`
 	req := httptest.NewRequest(http.MethodGet, "/", bytes.NewBufferString(jsonRequest))
 	w := httptest.NewRecorder()
-	NewExpressionHandler(w, req)
+	app.NewExpressionHandler(w, req)
 	res := w.Result()
 	defer res.Body.Close()
 	data, err := io.ReadAll(res.Body)
@@ -84,3 +86,67 @@ func TestIsIdExpressionRequest(t *testing.T) {
 }
 
 
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	deadline, err := (&Request{}).deadline(now)
+	if err != nil || deadline != nil {
+		t.Errorf("expected no deadline, got %v, %v", deadline, err)
+	}
+
+	deadline, err = (&Request{TimeoutMs: 1000, Deadline: &later}).deadline(now)
+	if err != nil || deadline == nil || !deadline.Equal(now.Add(time.Second)) {
+		t.Errorf("expected the earlier deadline, got %v, %v", deadline, err)
+	}
+
+	deadline, err = (&Request{Deadline: &later}).deadline(now)
+	if err != nil || deadline == nil || !deadline.Equal(later) {
+		t.Errorf("expected absolute deadline, got %v, %v", deadline, err)
+	}
+
+	if _, err = (&Request{TimeoutMs: -1}).deadline(now); err == nil {
+		t.Errorf("negative timeout must be rejected")
+	}
+}
+
+func TestRequestDefaults(t *testing.T) {
+	var request Request
+	if err := json.Unmarshal([]byte(`{"expression": "1+2"}`), &request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Без полей optimize и cache агенты получают все операции выражения.
+	if request.Optimize || request.Cache {
+		t.Errorf("expected optimize = false and cache = false, got %v and %v", request.Optimize, request.Cache)
+	}
+
+	request = Request{}
+	if err := json.Unmarshal([]byte(`{"expression": "1+2", "optimize": true, "cache": true}`), &request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !request.Optimize || !request.Cache {
+		t.Errorf("expected optimize = true and cache = true, got %v and %v", request.Optimize, request.Cache)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	cases := map[string]time.Duration{
+		"/api/v1/expressions/1":               0,
+		"/api/v1/expressions/1?wait_ms=0":     0,
+		"/api/v1/expressions/1?wait_ms=1500":  1500 * time.Millisecond,
+		"/api/v1/expressions/1?wait_ms=99999": time.Minute,
+	}
+	for path, expected := range cases {
+		u, _ := url.Parse(path)
+		if wait, err := waitTimeout(u); err != nil || wait != expected {
+			t.Errorf("%s: expected %v, got %v, %v", path, expected, wait, err)
+		}
+	}
+	for _, path := range []string{"/api/v1/expressions/1?wait_ms=-1", "/api/v1/expressions/1?wait_ms=soon"} {
+		u, _ := url.Parse(path)
+		if _, err := waitTimeout(u); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
+)
+
+type FunctionRequest struct {
+	Definition string `json:"definition"`
+}
+
+func FunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	userLogin := r.Context().Value("user_login").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		result, err := orkestrator.UserFunctions(userLogin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, result)
+	case http.MethodPost, http.MethodPut:
+		request := new(FunctionRequest)
+		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		function, err := orkestrator.DefineFunction(userLogin, request.Definition)
+		if err != nil {
+			// Ошибки определения функции имеют код, ошибки БД - нет.
+			if calc.ErrorCode(err) != "INTERNAL_ERROR" {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		jsonBytes, err := json.Marshal(function)
+		if err != nil {
+			panic(err)
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, string(jsonBytes))
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func FunctionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	userLogin := r.Context().Value("user_login").(string)
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/functions/")
+	if err := orkestrator.DeleteFunction(userLogin, name); err != nil {
+		if errors.Is(err, calc.ErrFunctionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
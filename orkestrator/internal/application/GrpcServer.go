@@ -7,6 +7,8 @@ import (
 	"net"
 	"os"
 
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
 	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
 	pb "github.com/veronicashkarova/server-for-calc/proto"
 	"google.golang.org/grpc"
@@ -15,10 +17,11 @@ import (
 
 type Server struct {
 	pb.CalculatorServiceServer // сервис из сгенерированного пакета
+	orkestrator                *orkestrator.Orkestrator
 }
 
-func NewServer() *Server {
-	return &Server{}
+func NewServer(orkestrator *orkestrator.Orkestrator) *Server {
+	return &Server{orkestrator: orkestrator}
 }
 
 type CalculatorServiceServer interface {
@@ -44,12 +47,21 @@ func (s *Server) GetTask(
 		return nil, fmt.Errorf("invalid task: task ID is zero")
 	}
 	fmt.Printf("GetTask: возвращаем задачу агенту: ID=%d\n", task.ID)
+	complexArgs := make([]*pb.Complex, len(task.ComplexArgs))
+	for i, arg := range task.ComplexArgs {
+		complexArgs[i] = &pb.Complex{Real: arg.Real, Imag: arg.Imag}
+	}
 	return &pb.Task{
 		Id:            int32(task.ID),
 		Arg1:          float32(task.Arg1),
 		Arg2:          float32(task.Arg2),
 		Operation:     task.Operation,
 		OperationTime: int32(task.OperationTime),
+		Args:          task.Args,
+		Precision:     task.Precision,
+		ExactArgs:     task.ExactArgs,
+		IntArgs:       task.IntArgs,
+		ComplexArgs:   complexArgs,
 	}, nil
 }
 
@@ -59,7 +71,22 @@ func (s *Server) GetResult(
 ) (*pb.EmptyResponse, error) {
 	fmt.Printf("GetResult: получен результат от агента: ID=%d, Result=%f\n", taskResult.Id, taskResult.Result)
 	resp := &pb.EmptyResponse{}
-	var resultErr = orkestrator.SendResult(int(taskResult.Id), float64(taskResult.Result))
+	result := contract.TaskResult{
+		ID:        int(taskResult.Id),
+		Result:    float64(taskResult.Result),
+		Exact:     taskResult.ExactResult,
+		IntResult: taskResult.IntResult,
+		Overflow:  taskResult.Overflow,
+		Agent:     taskResult.Agent,
+		AI:        taskResult.Ai,
+	}
+	if value := taskResult.ComplexResult; value != nil {
+		result.Complex = &contract.Complex{Real: value.Real, Imag: value.Imag}
+	}
+	if taskResult.Error != "" {
+		result.Err = fmt.Errorf("%w: %s", calc.ErrTaskFailed, taskResult.Error)
+	}
+	var resultErr = s.orkestrator.SendResult(result)
 	if resultErr != nil {
 		fmt.Printf("GetResult: ошибка отправки результата: %v\n", resultErr)
 		return resp, resultErr
@@ -68,7 +95,7 @@ func (s *Server) GetResult(
 	return resp, nil
 }
 
-func StartGrpcServer() {
+func (a *Application) StartGrpcServer() {
 	go func() {
 		host := "0.0.0.0" //localhost
 		port := "5000"
@@ -103,7 +130,7 @@ func StartGrpcServer() {
 		grpcServer := grpc.NewServer(grpc.Creds(creds))
 		// объект структуры, которая содержит реализацию
 		// серверной части GeometryService
-		calcServiceServer := NewServer()
+		calcServiceServer := NewServer(a.orkestrator)
 		// зарегистрируем нашу реализацию сервера
 		pb.RegisterCalculatorServiceServer(grpcServer, calcServiceServer)
 		// запустим grpc сервер
@@ -0,0 +1,71 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
+)
+
+func VariablesHandler(w http.ResponseWriter, r *http.Request) {
+	userLogin := r.Context().Value("user_login").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		result, err := orkestrator.Variables(userLogin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, result)
+	case http.MethodPost, http.MethodPut:
+		variable := new(contract.Variable)
+		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&variable)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := orkestrator.SetVariable(userLogin, *variable); err != nil {
+			if errors.Is(err, calc.ErrInvalidVariable) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		jsonBytes, err := json.Marshal(variable)
+		if err != nil {
+			panic(err)
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, string(jsonBytes))
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func VariableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	userLogin := r.Context().Value("user_login").(string)
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/variables/")
+	if err := orkestrator.DeleteVariable(userLogin, name); err != nil {
+		if errors.Is(err, calc.ErrVariableNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -3,12 +3,17 @@ package application
 import (
 	"crypto/tls"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
 	"github.com/veronicashkarova/server-for-calc/pkg/contract"
 	"github.com/veronicashkarova/server-for-calc/pkg/db"
+	"github.com/veronicashkarova/server-for-calc/pkg/orkestrator"
 )
 
 func ConfigFromEnv() *contract.Config {
@@ -34,7 +39,7 @@ func ConfigFromEnv() *contract.Config {
 	if err == nil {
 		config.TIME_MULTIPLICATIONS_MS = mulTime
 	} else {
-		config.TIME_SUBTRACTION_MS = 1000
+		config.TIME_MULTIPLICATIONS_MS = 1000
 	}
 	divTime, err := strconv.Atoi(os.Getenv("TIME_DIVISIONS_MS"))
 	if err == nil {
@@ -42,22 +47,123 @@ func ConfigFromEnv() *contract.Config {
 	} else {
 		config.TIME_DIVISIONS_MS = 1000
 	}
+	intDivTime, err := strconv.Atoi(os.Getenv("TIME_INTEGER_DIVISIONS_MS"))
+	if err == nil {
+		config.TIME_INTEGER_DIVISIONS_MS = intDivTime
+	} else {
+		config.TIME_INTEGER_DIVISIONS_MS = 1000
+	}
+	modTime, err := strconv.Atoi(os.Getenv("TIME_MODULO_MS"))
+	if err == nil {
+		config.TIME_MODULO_MS = modTime
+	} else {
+		config.TIME_MODULO_MS = 1000
+	}
+	powTime, err := strconv.Atoi(os.Getenv("TIME_EXPONENTIATIONS_MS"))
+	if err == nil {
+		config.TIME_EXPONENTIATIONS_MS = powTime
+	} else {
+		config.TIME_EXPONENTIATIONS_MS = 1000
+	}
+	bitwiseTime, err := strconv.Atoi(os.Getenv("TIME_BITWISE_MS"))
+	if err == nil {
+		config.TIME_BITWISE_MS = bitwiseTime
+	} else {
+		config.TIME_BITWISE_MS = 1000
+	}
+	comparisonTime, err := strconv.Atoi(os.Getenv("TIME_COMPARISON_MS"))
+	if err == nil {
+		config.TIME_COMPARISON_MS = comparisonTime
+	} else {
+		config.TIME_COMPARISON_MS = 1000
+	}
+	leaseTime, err := strconv.Atoi(os.Getenv("TASK_LEASE_MS"))
+	if err == nil {
+		config.TASK_LEASE_MS = leaseTime
+	} else {
+		config.TASK_LEASE_MS = 30000
+	}
+	maxRetries, err := strconv.Atoi(os.Getenv("TASK_MAX_RETRIES"))
+	if err == nil {
+		config.TASK_MAX_RETRIES = maxRetries
+	} else {
+		config.TASK_MAX_RETRIES = 3
+	}
+	retention, err := strconv.Atoi(os.Getenv("EXPRESSION_RETENTION_MS"))
+	if err == nil {
+		config.EXPRESSION_RETENTION_MS = retention
+	} else {
+		config.EXPRESSION_RETENTION_MS = 600000
+	}
+	foldLimit, err := strconv.Atoi(os.Getenv("OPTIMIZE_FOLD_LIMIT"))
+	if err == nil {
+		config.OPTIMIZE_FOLD_LIMIT = foldLimit
+	} else {
+		config.OPTIMIZE_FOLD_LIMIT = 10
+	}
+	cacheSize, err := strconv.Atoi(os.Getenv("CACHE_SIZE"))
+	if err == nil {
+		config.CACHE_SIZE = cacheSize
+	} else {
+		config.CACHE_SIZE = 10000
+	}
+	cacheTTL, err := strconv.Atoi(os.Getenv("CACHE_TTL_MS"))
+	if err == nil {
+		config.CACHE_TTL_MS = cacheTTL
+	} else {
+		config.CACHE_TTL_MS = 600000
+	}
+	// Время вычисления функции задается переменной TIME_<ИМЯ>_MS, например TIME_SQRT_MS.
+	config.TIME_FUNCTIONS_MS = make(map[string]int)
+	for _, name := range calc.Functions.Names() {
+		functionTime, err := strconv.Atoi(os.Getenv("TIME_" + strings.ToUpper(name) + "_MS"))
+		if err == nil {
+			config.TIME_FUNCTIONS_MS[name] = functionTime
+		} else {
+			config.TIME_FUNCTIONS_MS[name] = 1000
+		}
+	}
 	return config
 }
 
 type Application struct {
-	config *contract.Config
+	config      *contract.Config
+	orkestrator *orkestrator.Orkestrator
 }
 
 func New() *Application {
 	contract.AppConfig = ConfigFromEnv()
+	calc.Cache = calc.NewOperationCache(contract.AppConfig.CACHE_SIZE, time.Duration(contract.AppConfig.CACHE_TTL_MS)*time.Millisecond)
 	return &Application{
-		config: contract.AppConfig,
+		config:      contract.AppConfig,
+		orkestrator: orkestrator.New(orkestrator.NewRegistry()),
 	}
 }
 
 type Request struct {
-	Expression string `json:"expression"`
+	Expression string     `json:"expression"`
+	TimeoutMs  int        `json:"timeout_ms"`
+	Deadline   *time.Time `json:"deadline"`
+	// Precision - "float" (по умолчанию), "decimal", "rational", "integer" или "complex".
+	Precision string `json:"precision"`
+	Optimize  bool   `json:"optimize"`
+	Cache     bool   `json:"cache"`
+	Script    string `json:"script"`
+}
+
+// deadline возвращает более ранний из timeout_ms и deadline или nil.
+func (r *Request) deadline(now time.Time) (*time.Time, error) {
+	if r.TimeoutMs < 0 {
+		return nil, calc.ErrInvalidDeadline
+	}
+	deadline := r.Deadline
+	if r.TimeoutMs > 0 {
+		timeout := now.Add(time.Duration(r.TimeoutMs) * time.Millisecond)
+		if deadline == nil || timeout.Before(*deadline) {
+			deadline = &timeout
+		}
+	}
+	return deadline, nil
 }
 
 type TaskRequest struct {
@@ -69,13 +175,29 @@ func (a *Application) RunServer() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/register", RegisterUserHandler)
 	mux.HandleFunc("/api/v1/login", LoginUserHandler)
-	calculate := AutorizationMiddleware(http.HandlerFunc(NewExpressionHandler))
+	calculate := AutorizationMiddleware(http.HandlerFunc(a.NewExpressionHandler))
+	scripts := AutorizationMiddleware(http.HandlerFunc(a.NewScriptHandler))
+	validate := AutorizationMiddleware(http.HandlerFunc(a.ValidateHandler))
 	expressions := AutorizationMiddleware(http.HandlerFunc(ExpressionsHandler))
-	idExpressions := AutorizationMiddleware(http.HandlerFunc(IdHandler))
+	idExpressions := AutorizationMiddleware(http.HandlerFunc(a.IdHandler))
+	variables := AutorizationMiddleware(http.HandlerFunc(VariablesHandler))
+	variable := AutorizationMiddleware(http.HandlerFunc(VariableHandler))
+	functions := AutorizationMiddleware(http.HandlerFunc(FunctionsHandler))
+	cache := AutorizationMiddleware(http.HandlerFunc(CacheHandler))
+	function := AutorizationMiddleware(http.HandlerFunc(FunctionHandler))
 	mux.Handle("/api/v1/calculate", calculate)
+	mux.Handle("/api/v1/scripts", scripts)
+	mux.Handle("/api/v1/validate", validate)
 	mux.Handle("/api/v1/expressions", expressions)
 	mux.Handle("/api/v1/expressions/", idExpressions)
-	StartGrpcServer()
+	mux.Handle("/api/v1/variables", variables)
+	mux.Handle("/api/v1/variables/", variable)
+	mux.Handle("/api/v1/functions", functions)
+	mux.Handle("/api/v1/functions/", function)
+	mux.Handle("/api/v1/cache", cache)
+	a.StartGrpcServer()
+	a.orkestrator.StartLeaseWatcher(time.Second)
+	a.orkestrator.StartEviction(time.Minute, time.Duration(a.config.EXPRESSION_RETENTION_MS)*time.Millisecond)
 
 	// Загружаем TLS сертификаты для HTTPS
 	cert, err := tls.LoadX509KeyPair("certs/server.crt", "certs/server.key")
@@ -101,4 +223,11 @@ func (a *Application) RunServer() error {
 
 func (a *Application) CreareDataBase() {
 	db.CreateDb()
+	calc.Journal = db.TaskJournal{}
+}
+
+func (a *Application) ResumeExpressions() {
+	if err := a.orkestrator.ResumeExpressions(); err != nil {
+		log.Printf("ошибка восстановления выражений: %v", err)
+	}
 }
@@ -0,0 +1,79 @@
+package calc
+
+// Expr - узел синтаксического дерева выражения.
+type Expr interface {
+	Position() Pos
+}
+
+type NumberExpr struct {
+	Value float64
+	Text  string
+	Pos   Pos
+}
+
+// BinaryExpr - бинарная операция Left Op Right.
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+	Pos   Pos
+}
+
+// UnaryExpr - унарная операция Op Operand.
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+	Pos     Pos
+}
+
+// IdentExpr - имя константы или переменной.
+type IdentExpr struct {
+	Name string
+	Pos  Pos
+}
+
+// CallExpr - вызов функции Name(Args...).
+type CallExpr struct {
+	Name string
+	Args []Expr
+	Pos  Pos
+}
+
+type ScriptExpr struct {
+	Statements []Statement
+	Pos        Pos
+}
+
+// Statement без Name - выражение, значение которого не присваивается.
+type Statement struct {
+	Name string
+	Expr Expr
+	Pos  Pos
+}
+
+func (e *NumberExpr) Position() Pos { return e.Pos }
+func (e *IdentExpr) Position() Pos  { return e.Pos }
+func (e *BinaryExpr) Position() Pos { return e.Pos }
+func (e *UnaryExpr) Position() Pos  { return e.Pos }
+func (e *CallExpr) Position() Pos   { return e.Pos }
+func (e *ScriptExpr) Position() Pos { return e.Pos }
+
+// Walk вызывает visit для expr и всех вложенных в него выражений.
+func Walk(expr Expr, visit func(Expr)) {
+	visit(expr)
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		Walk(e.Left, visit)
+		Walk(e.Right, visit)
+	case *UnaryExpr:
+		Walk(e.Operand, visit)
+	case *CallExpr:
+		for _, arg := range e.Args {
+			Walk(arg, visit)
+		}
+	case *ScriptExpr:
+		for _, statement := range e.Statements {
+			Walk(statement.Expr, visit)
+		}
+	}
+}
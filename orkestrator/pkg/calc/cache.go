@@ -0,0 +1,128 @@
+package calc
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OperationCache отправляет агентам одинаковую операцию разных выражений один раз.
+type OperationCache struct {
+	mutex sync.Mutex
+	// При size <= 0 результаты не хранятся, но одновременные операции объединяются.
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	flights map[string][]func(entry cacheEntry, ok bool)
+	stats   CacheStats
+}
+
+type CacheStats struct {
+	Size      int `json:"size"`
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Coalesced int `json:"coalesced"`
+}
+
+type cacheEntry struct {
+	key     string
+	result  Number
+	agent   string
+	expires time.Time
+}
+
+type cacheStatus int
+
+const (
+	cacheMiss cacheStatus = iota
+	cacheHit
+	cacheJoined
+)
+
+func NewOperationCache(size int, ttl time.Duration) *OperationCache {
+	return &OperationCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		flights: make(map[string][]func(cacheEntry, bool)),
+	}
+}
+
+var Cache = NewOperationCache(0, 0)
+
+// acquire вызывает wait, если такая операция уже вычисляется. При cacheMiss
+// результат нужно сообщить через release.
+func (c *OperationCache) acquire(key string, wait func(entry cacheEntry, ok bool)) (cacheEntry, cacheStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, found := c.entries[key]; found {
+		entry := element.Value.(cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(element)
+			c.stats.Hits++
+			return entry, cacheHit
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	if waiters, found := c.flights[key]; found {
+		c.flights[key] = append(waiters, wait)
+		c.stats.Coalesced++
+		return cacheEntry{}, cacheJoined
+	}
+	c.flights[key] = nil
+	c.stats.Misses++
+	return cacheEntry{}, cacheMiss
+}
+
+// Если ok равно false, ожидающие сами отправляют задачи агентам.
+func (c *OperationCache) release(key string, result Number, agent string, ok bool) {
+	c.mutex.Lock()
+	waiters := c.flights[key]
+	delete(c.flights, key)
+	entry := cacheEntry{key: key, result: result, agent: agent, expires: time.Now().Add(c.ttl)}
+	if ok && c.size > 0 && c.ttl > 0 {
+		if element, found := c.entries[key]; found {
+			c.order.Remove(element)
+		}
+		c.entries[key] = c.order.PushFront(entry)
+		for c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(cacheEntry).key)
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, wait := range waiters {
+		wait(entry, ok)
+	}
+}
+
+func (c *OperationCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+func (g graph) cacheKey(n *node) string {
+	var b strings.Builder
+	b.WriteString(string(g.precision))
+	b.WriteString(" " + n.operation)
+	for _, arg := range n.args {
+		b.WriteString(" " + arg.String())
+	}
+	return b.String()
+}
+
+// Недетерминированные функции каждый раз вычисляются заново.
+func cacheable(n *node) bool {
+	return n.function == nil || !n.function.Nondeterministic
+}
@@ -1,158 +1,92 @@
 package calc
 
 import (
+	"context"
 	"fmt"
-	"strconv"
 
 	"github.com/veronicashkarova/server-for-calc/pkg/contract"
 )
 
-type stack struct {
-	stack []string
+func Calc(ctx context.Context, expression string, id string, taskChan chan contract.TaskData, results chan contract.TaskResult) (Number, error) {
+	return Resume(ctx, expression, id, Scope{}, nil, taskChan, results)
 }
 
-func newstack() stack {
-	return stack{stack: []string{}}
+// Resume не отправляет агентам операции из done.
+func Resume(ctx context.Context, expression string, id string, scope Scope, done map[int]string, taskChan chan contract.TaskData, results chan contract.TaskResult) (Number, error) {
+	fmt.Printf("Calc: начало обработки выражения '%s' с ID=%s\n", expression, id)
+	expr, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := buildGraph(expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	return runGraph(ctx, id, graph, done, taskChan, results)
 }
 
-func (s *stack) push(val string) {
-	s.stack = append(s.stack, val)
+// Plan - то, что известно о выражении до вычисления.
+type Plan struct {
+	// Canonical - выражение в каноническом виде (см. Format).
+	Canonical   string `json:"canonical"`
+	AST         Node   `json:"ast"`
+	Tasks       int    `json:"tasks"`
+	SavedTasks  int    `json:"saved_tasks"`
+	EstimatedMs int    `json:"estimated_ms"`
 }
 
-func (s *stack) getTop() string {
-	if len(s.stack) != 0 {
-		return s.stack[len(s.stack)-1]
-	} else {
-		return ""
+// Validate проверяет выражение, ничего не отправляя агентам.
+func Validate(expression string, scope Scope) (Plan, error) {
+	expr, err := Parse(expression)
+	if err != nil {
+		return Plan{}, err
 	}
-}
-
-func (s *stack) pop() string {
-	if len(s.stack) != 0 {
-		r := s.stack[len(s.stack)-1]
-		s.stack = s.stack[:len(s.stack)-1]
-		return r
-	} else {
-		return ""
+	graph, err := buildGraph(expr, scope)
+	if err != nil {
+		return Plan{}, err
 	}
-}
-
-func Calc(expression string, id string, taskChan chan contract.TaskData) (float64, error) {
-	fmt.Printf("Calc: начало обработки выражения '%s' с ID=%s\n", expression, id)
-	var bufnum string
-	var rpnarr []string
-	rpnstack := newstack()
-
-	for _, v := range expression {
-		if _, err := strconv.Atoi(string(v)); err == nil || string(v) == "." {
-			bufnum += string(v)
-		} else if string(v) == ")" {
-			if bufnum != "" {
-				rpnarr = append(rpnarr, bufnum)
-				bufnum = ""
-			}
-
-			for rpnstack.getTop() != "(" {
-				if rpnstack.getTop() == "" {
-					return 0, ErrMissingBracket
-				}
-				rpnarr = append(rpnarr, rpnstack.pop())
-			}
-			rpnstack.pop()
-		} else {
-			// Нормализуем символ умножения: × (U+00D7) и · (U+00B7) -> *
-			normalizedOp := string(v)
-			if normalizedOp == "×" || normalizedOp == "·" {
-				normalizedOp = "*"
-			}
-			
-			flag := false
-			allops := [6]string{"/", "*", "-", "+", "(", ")"}
-			for _, op := range allops {
-				if op == normalizedOp {
-					flag = true
-					break
-				}
-			}
-			if !flag {
-				return 0, ErrIllegalSign
-			}
-
-			if bufnum != "" {
-				rpnarr = append(rpnarr, bufnum)
-				bufnum = ""
-			}
-
-			if rpnstack.getTop() == "(" || normalizedOp == "(" || rpnstack.getTop() == "" ||
-				((rpnstack.getTop() == "+" || rpnstack.getTop() == "-") && (normalizedOp == "*" || normalizedOp == "/")) {
-				rpnstack.push(normalizedOp)
-			} else {
-				for !((rpnstack.getTop() == "+" || rpnstack.getTop() == "-") && (normalizedOp == "*" || normalizedOp == "/")) && rpnstack.getTop() != "(" && rpnstack.getTop() != "" {
-					rpnarr = append(rpnarr, rpnstack.pop())
-				}
-				rpnstack.push(normalizedOp)
-			}
+	required := graph.required()
+	for _, n := range graph.nodes {
+		if n.pending > 0 || !required[n] {
+			continue
+		}
+		if err := graph.check(n); err != nil {
+			return Plan{}, err
 		}
 	}
-	if bufnum != "" {
-		rpnarr = append(rpnarr, bufnum)
-		bufnum = ""
-	}
-	for rpnstack.getTop() != "" {
-		rpnarr = append(rpnarr, rpnstack.pop())
+	saved, err := savedTasks(expr, scope, graph)
+	if err != nil {
+		return Plan{}, err
 	}
-	for _, v := range rpnarr {
-		if _, err := strconv.ParseFloat(v, 64); err == nil {
-			rpnstack.push(v)
-		} else {
-			rawo2 := rpnstack.pop()
-			rawo1 := rpnstack.pop()
-			if rawo1 == "" || rawo2 == "" {
-				return 0, ErrInvalidExpression
-			}
-			o2, _ := strconv.ParseFloat(rawo2, 64)
-			o1, _ := strconv.ParseFloat(rawo1, 64)
-			if v == "+" {
-				rpnstack.push(strconv.FormatFloat(
-					WaitResult(id, o1, o2, v, contract.AppConfig.TIME_ADDITION_MS, taskChan), 'f', -1, 64))
-			} else if v == "-" {
-				rpnstack.push(strconv.FormatFloat(
-					WaitResult(id, o1, o2, v, contract.AppConfig.TIME_SUBTRACTION_MS, taskChan), 'f', -1, 64))
-			} else if v == "*" {
-				rpnstack.push(strconv.FormatFloat(
-					WaitResult(id, o1, o2, v, contract.AppConfig.TIME_MULTIPLICATIONS_MS, taskChan), 'f', -1, 64))
-			} else if v == "/" {
-				if o2 == 0 {
-					return 0, ErrNullDivision
-				}
-				rpnstack.push(strconv.FormatFloat(
-					WaitResult(id, o1, o2, v, contract.AppConfig.TIME_DIVISIONS_MS, taskChan), 'f', -1, 64))
-			} else if v == "(" {
-				return 0, ErrMissingBracket
-			}
-		}
+	return Plan{
+		Canonical:   Format(expr),
+		AST:         Tree(expr),
+		Tasks:       graph.tasks(),
+		SavedTasks:  saved,
+		EstimatedMs: graph.criticalPath(),
+	}, nil
+}
+
+func SavedTasks(expression string, scope Scope) (int, error) {
+	expr, err := ParseScript(expression)
+	if err != nil {
+		return 0, err
 	}
-	res, err := strconv.ParseFloat(rpnstack.pop(), 64)
+	graph, err := buildGraph(expr, scope)
 	if err != nil {
 		return 0, err
 	}
-	return res, nil
+	return savedTasks(expr, scope, graph)
 }
 
-func WaitResult(strId string, arg1 float64, arg2 float64, operation string, delay int, taskChan chan contract.TaskData) float64 {
-	fmt.Printf("WaitResult: отправка задачи для выражения %s: %f %s %f (задержка: %d мс)\n", strId, arg1, operation, arg2, delay)
-	id, _ := strconv.Atoi(strId)
-	taskData := contract.TaskData{
-		ID:            id,
-		Arg1:          arg1,
-		Arg2:          arg2,
-		Operation:     operation,
-		OperationTime: delay,
+func savedTasks(expr Expr, scope Scope, optimized graph) (int, error) {
+	if !scope.Optimize {
+		return 0, nil
+	}
+	scope.Optimize = false
+	full, err := buildGraph(expr, scope)
+	if err != nil {
+		return 0, err
 	}
-	fmt.Printf("WaitResult: отправка задачи в канал TaskChannel: ID=%d, Arg1=%f, Arg2=%f, Operation=%s\n", taskData.ID, taskData.Arg1, taskData.Arg2, taskData.Operation)
-	taskChan <- taskData
-	fmt.Printf("WaitResult: задача отправлена, ожидание результата из ExpChan для выражения %s\n", strId)
-	result := <-contract.ExpressionMap[strId].ExpChan
-	fmt.Printf("WaitResult: получен результат для выражения %s: %f\n", strId, result)
-	return result
+	return full.tasks() - optimized.tasks(), nil
 }
@@ -0,0 +1,1303 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+func execute(task contract.TaskData) float64 {
+	switch task.Operation {
+	case "+":
+		return task.Arg1 + task.Arg2
+	case "-":
+		return task.Arg1 - task.Arg2
+	case "*":
+		return task.Arg1 * task.Arg2
+	case "/":
+		return task.Arg1 / task.Arg2
+	case OperationNeg:
+		return -task.Arg1
+	case "^":
+		return math.Pow(task.Arg1, task.Arg2)
+	case "%":
+		return math.Mod(task.Arg1, task.Arg2)
+	case "//":
+		return math.Floor(task.Arg1 / task.Arg2)
+	case "sqrt":
+		return math.Sqrt(task.Args[0])
+	case "max":
+		result := task.Args[0]
+		for _, arg := range task.Args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result
+	case "==":
+		return truth(task.Arg1 == task.Arg2)
+	case "!=":
+		return truth(task.Arg1 != task.Arg2)
+	case "<":
+		return truth(task.Arg1 < task.Arg2)
+	case "<=":
+		return truth(task.Arg1 <= task.Arg2)
+	case ">":
+		return truth(task.Arg1 > task.Arg2)
+	case ">=":
+		return truth(task.Arg1 >= task.Arg2)
+	case OperationLogicalNot:
+		return truth(task.Arg1 == 0)
+	}
+	return 0
+}
+
+// truth возвращает результат сравнения так, как его присылают агенты.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// executeExact выполняет задачу точного режима над точными аргументами.
+func executeExact(task contract.TaskData) string {
+	args := make([]*big.Rat, len(task.ExactArgs))
+	for i, arg := range task.ExactArgs {
+		args[i], _ = new(big.Rat).SetString(arg)
+	}
+	result := new(big.Rat)
+	switch task.Operation {
+	case "+":
+		result.Add(args[0], args[1])
+	case "-":
+		result.Sub(args[0], args[1])
+	case "*":
+		result.Mul(args[0], args[1])
+	case "/":
+		result.Quo(args[0], args[1])
+	case OperationNeg:
+		result.Neg(args[0])
+	}
+	if task.Precision == string(PrecisionRational) {
+		return result.RatString()
+	}
+	return formatDecimal(result)
+}
+
+// executeInteger выполняет задачу режима integer. Переполнение
+// проверяется с помощью big.Int, как у агентов.
+func executeInteger(task contract.TaskData) contract.TaskResult {
+	a, b := big.NewInt(task.IntArgs[0]), new(big.Int)
+	if len(task.IntArgs) > 1 {
+		b.SetInt64(task.IntArgs[1])
+	}
+	result := new(big.Int)
+	switch task.Operation {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+	case "&":
+		result.And(a, b)
+	case "|":
+		result.Or(a, b)
+	case "<<":
+		result.Lsh(a, uint(b.Int64()))
+	case ">>":
+		result.Rsh(a, uint(b.Int64()))
+	case OperationNot:
+		result.Not(a)
+	case ">=":
+		result.SetInt64(int64(truth(a.Cmp(b) >= 0)))
+	}
+	if !result.IsInt64() {
+		return contract.TaskResult{ID: task.ID, Overflow: true}
+	}
+	return contract.TaskResult{ID: task.ID, Result: float64(result.Int64()), IntResult: result.Int64()}
+}
+
+// executeComplex выполняет задачу режима complex.
+func executeComplex(task contract.TaskData) contract.TaskResult {
+	args := make([]complex128, len(task.ComplexArgs))
+	for i, arg := range task.ComplexArgs {
+		args[i] = complex(arg.Real, arg.Imag)
+	}
+	var result complex128
+	switch task.Operation {
+	case "+":
+		result = args[0] + args[1]
+	case "-":
+		result = args[0] - args[1]
+	case "*":
+		result = args[0] * args[1]
+	case "/":
+		result = args[0] / args[1]
+	case OperationNeg:
+		result = -args[0]
+	case "sqrt":
+		result = cmplx.Sqrt(args[0])
+	case "==":
+		result = complex(truth(args[0] == args[1]), 0)
+	}
+	return contract.TaskResult{ID: task.ID, Result: real(result), Complex: &contract.Complex{Real: real(result), Imag: imag(result)}}
+}
+
+// runAgent выполняет задачи из taskChan, пока не закончится тест.
+func runAgent(t *testing.T, taskChan chan contract.TaskData, results chan contract.TaskResult) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case task := <-taskChan:
+				result := contract.TaskResult{ID: task.ID, Result: execute(task)}
+				switch task.Precision {
+				case string(PrecisionInteger):
+					result = executeInteger(task)
+				case string(PrecisionComplex):
+					result = executeComplex(task)
+				case string(PrecisionDecimal), string(PrecisionRational):
+					result.Exact = executeExact(task)
+				}
+				results <- result
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func TestCalcDispatchesIndependentTasksTogether(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+
+	go func() {
+		// Обе суммы должны оказаться в очереди до того, как придет хоть один результат.
+		first, second := <-taskChan, <-taskChan
+		if first.Operation != "+" || second.Operation != "+" {
+			t.Errorf("expected two additions, got %q and %q", first.Operation, second.Operation)
+		}
+		results <- contract.TaskResult{ID: first.ID, Result: execute(first)}
+		results <- contract.TaskResult{ID: second.ID, Result: execute(second)}
+		last := <-taskChan
+		results <- contract.TaskResult{ID: last.ID, Result: execute(last)}
+	}()
+
+	result, err := Calc(context.Background(), "(1+2)*(3+4)", "1", taskChan, results)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Float64() != 21 {
+		t.Errorf("expected 21, got %s", result)
+	}
+}
+
+func TestCalcErrors(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+
+	cases := []struct {
+		expression string
+		err        error
+	}{
+		{"1/0", ErrNullDivision},
+		{"1%0", ErrNullDivision},
+		{"1//0", ErrNullDivision},
+		{"1+", ErrInvalidExpression},
+		{"(1+2", ErrMissingBracket},
+		{"1+2)", ErrMissingBracket},
+		{"1$2", ErrIllegalSign},
+		{"foo(1)", ErrUnknownFunction},
+		{"sqrt(1, 2)", ErrArgumentCount},
+		{"max()", ErrArgumentCount},
+		{"sqrt(-4)", ErrInvalidArgument},
+		{"log(0)", ErrInvalidArgument},
+		{"rate*2", ErrUnknownVariable},
+	}
+	for _, c := range cases {
+		if _, err := Calc(context.Background(), c.expression, "1", taskChan, results); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.expression, c.err, err)
+		}
+	}
+}
+
+func TestTaskStoreRejectsUnknownAndFinishedTasks(t *testing.T) {
+	store := NewTaskStore()
+	task := store.Add(contract.Task{ExpressionID: "1", Slot: 1})
+	if task.ID == 0 || task.Data.ID != task.ID {
+		t.Fatalf("task id is not assigned: %+v", task)
+	}
+
+	if _, err := store.Finish(task.ID + 1); err != ErrUnknownTask {
+		t.Errorf("expected ErrUnknownTask, got %v", err)
+	}
+	finished, err := store.Finish(task.ID)
+	if err != nil || finished.ExpressionID != "1" || finished.Slot != 1 {
+		t.Errorf("unexpected finish result: %+v, %v", finished, err)
+	}
+	if _, err := store.Finish(task.ID); err != ErrTaskFinished {
+		t.Errorf("expected ErrTaskFinished, got %v", err)
+	}
+
+	store.Forget("1")
+	if _, err := store.Finish(task.ID); err != ErrUnknownTask {
+		t.Errorf("expected ErrUnknownTask after Forget, got %v", err)
+	}
+}
+
+func TestTaskStoreExpireRequeuesThenFails(t *testing.T) {
+	store := NewTaskStore()
+	task := store.Add(contract.Task{ExpressionID: "1"})
+	now := time.Now()
+
+	if _, err := store.Lease(task.ID, now); err != nil {
+		t.Fatalf("unexpected lease error: %v", err)
+	}
+	if _, err := store.Lease(task.ID, now); err == nil {
+		t.Errorf("leased task must not be leased twice")
+	}
+	requeue, failed := store.Expire(now.Add(time.Second), 1)
+	if len(requeue) != 1 || len(failed) != 0 {
+		t.Fatalf("expected task to be requeued, got %v and %v", requeue, failed)
+	}
+
+	if _, err := store.Lease(task.ID, now); err != nil {
+		t.Fatalf("unexpected lease error: %v", err)
+	}
+	requeue, failed = store.Expire(now.Add(time.Second), 1)
+	if len(requeue) != 0 || len(failed) != 1 {
+		t.Fatalf("expected task to fail, got %v and %v", requeue, failed)
+	}
+	if _, err := store.Finish(task.ID); err != ErrTaskFinished {
+		t.Errorf("expected late result to be rejected, got %v", err)
+	}
+}
+
+func TestResumeSkipsFinishedOperations(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			task := <-taskChan
+			if task.Arg1 == 1 && task.Arg2 == 2 {
+				t.Errorf("finished operation was dispatched again")
+			}
+			results <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+		}
+	}()
+
+	// Операция 0 (1+2) уже была вычислена до перезапуска.
+	result, err := Resume(context.Background(), "(1+2)*(3+4)", "1", Scope{}, map[int]string{0: "3"}, taskChan, results)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Float64() != 21 {
+		t.Errorf("expected 21, got %s", result)
+	}
+}
+
+// traceJournal запоминает шаги трассировки выражений.
+type traceJournal struct {
+	emptyJournal
+	steps []contract.TraceStep
+}
+
+func (j *traceJournal) SaveStep(expressionID string, step contract.TraceStep) error {
+	j.steps = append(j.steps, step)
+	return nil
+}
+
+func TestCalcSavesTrace(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	journal := &traceJournal{}
+	Journal = journal
+	t.Cleanup(func() { Journal = emptyJournal{} })
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			task := <-taskChan
+			if _, err := Tasks.Lease(task.ID, time.Now().Add(time.Minute)); err != nil {
+				t.Errorf("unexpected lease error: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			if _, err := Tasks.Finish(task.ID); err != nil {
+				t.Errorf("unexpected finish error: %v", err)
+			}
+			results <- contract.TaskResult{ID: task.ID, Result: execute(task), Agent: "host/agent-1"}
+		}
+	}()
+
+	if _, err := Calc(context.Background(), "(1+2)*4", "1", taskChan, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []contract.TraceStep{
+		{Operation: "+", Operands: []string{"1", "2"}, Result: "3"},
+		{Operation: "*", Operands: []string{"3", "4"}, Result: "12"},
+	}
+	if len(journal.steps) != len(expected) {
+		t.Fatalf("expected %d steps, got %+v", len(expected), journal.steps)
+	}
+	for i, step := range journal.steps {
+		if step.Operation != expected[i].Operation || step.Result != expected[i].Result ||
+			strings.Join(step.Operands, " ") != strings.Join(expected[i].Operands, " ") {
+			t.Errorf("step %d: expected %+v, got %+v", i, expected[i], step)
+		}
+		if step.Agent != "host/agent-1" || step.Attempts != 1 || step.ComputeMs < 10 {
+			t.Errorf("step %d: unexpected agent, attempts or timing: %+v", i, step)
+		}
+	}
+}
+
+func TestCalcStopsOnCancel(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-taskChan
+		cancel()
+	}()
+
+	if _, err := Calc(ctx, "1+2", "1", taskChan, results); err != ErrCancelled {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestCalcFailsAfterDeadline(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := Calc(ctx, "1+2", "1", taskChan, results); err != ErrDeadlineExceeded {
+		t.Errorf("expected ErrDeadlineExceeded, got %v", err)
+	}
+}
+
+func TestCalcOperators(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	runAgent(t, taskChan, results)
+
+	cases := []struct {
+		expression string
+		result     float64
+	}{
+		{"-5+3", -2},
+		{"2*-3", -6},
+		{"-(4+1)", -5},
+		{"-(-(1+1))", 2},
+		{"+7", 7},
+		{"-8", -8},
+		{"2^3^2", 512},
+		{"-2^2", -4},
+		{"7%3", 1},
+		{"7//2", 3},
+		{"-7//2", -4},
+		{"sqrt(16)*max(3,4)", 16},
+		{"max(1, 2+3, sqrt(9))", 5},
+		{"-sqrt(4)", -2},
+	}
+	for _, c := range cases {
+		result, err := Calc(context.Background(), c.expression, "1", taskChan, results)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expression, err)
+			continue
+		}
+		if result.Float64() != c.result {
+			t.Errorf("%s: expected %f, got %s", c.expression, c.result, result)
+		}
+	}
+}
+
+func TestCalcConditional(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Variables: map[string]float64{"x": 3}}
+	cases := []struct {
+		expression string
+		precision  Precision
+		result     string
+		err        error
+	}{
+		{"if(x > 10, x*2, x/2)", "", "1.500", nil},
+		{"if(x <= 10, x*2, x/2)", "", "6.000", nil},
+		{"x == 3", "", "true", nil},
+		{"x != 3 || x >= 4", "", "false", nil},
+		{"!(x < 2) && true", "", "true", nil},
+		{"if(true, 1, y)", "", "1.000", nil},
+		// Невыбранная ветвь не вычисляется, поэтому ее ошибки не важны.
+		{"x > 5 && 1/0 > 0", "", "false", nil},
+		{"x > 1 || sqrt(-1) > 0", "", "true", nil},
+		{"if(x > 1, if(x > 2, 1, 2), 3) + 1", "", "2.000", nil},
+		{"(6 & 3) >= 2", PrecisionInteger, "true", nil},
+		{"(1+2i)*1i == -2+1i", PrecisionComplex, "true", nil},
+		{"1i < 2", PrecisionComplex, "", ErrUnsupported},
+		{"1 + (x > 1)", "", "", ErrTypeMismatch},
+		{"if(x, 1, 2)", "", "", ErrTypeMismatch},
+		{"if(x > 1, 1, x > 2)", "", "", ErrTypeMismatch},
+		{"!x", "", "", ErrTypeMismatch},
+		{"-(x > 1)", "", "", ErrTypeMismatch},
+		{"if(x > 1, 2)", "", "", ErrArgumentCount},
+	}
+	for _, c := range cases {
+		scope.Precision = c.precision
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.expression, c.err, err)
+			continue
+		}
+		if err == nil && FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.result, FormatResult(result))
+		}
+	}
+
+	if err := CheckVariableName("true"); !errors.Is(err, ErrInvalidVariable) {
+		t.Errorf("expected %v for true, got %v", ErrInvalidVariable, err)
+	}
+	if err := CheckVariableName("if"); !errors.Is(err, ErrInvalidVariable) {
+		t.Errorf("expected %v for if, got %v", ErrInvalidVariable, err)
+	}
+}
+
+func TestCalcConditionalSkipsBranch(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+
+	operations := make(chan string, 10)
+	go func() {
+		for i := 0; i < 2; i++ {
+			task := <-taskChan
+			operations <- task.Operation
+			results <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+		}
+	}()
+
+	result, err := Calc(context.Background(), "if(2 > 1, 2*3, 4/5)", "1", taskChan, results)
+	if err != nil || result.Float64() != 6 {
+		t.Fatalf("expected 6, got %v, %v", result, err)
+	}
+	if first, second := <-operations, <-operations; first != ">" || second != "*" {
+		t.Errorf("expected > and *, got %s and %s", first, second)
+	}
+
+	// Условие (операция 0) вычислено до перезапуска: агенты получают
+	// только операцию выбранной ветви.
+	go func() {
+		task := <-taskChan
+		if task.Operation != "*" || task.Arg1 != 5 {
+			t.Errorf("expected 5*6, got %v", task)
+		}
+		results <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+	}()
+	result, err = Resume(context.Background(), "if(1 > 2, 3*4, 5*6)", "1", Scope{}, map[int]string{0: "false"}, taskChan, results)
+	if err != nil || result.Float64() != 30 {
+		t.Errorf("expected 30, got %v, %v", result, err)
+	}
+
+	plan, err := Validate("if(1 > 2, 3*4, 5/0)", Scope{})
+	if err != nil || plan.Tasks != 3 {
+		t.Errorf("expected 3 tasks, got %+v, %v", plan, err)
+	}
+}
+
+func TestCalcScript(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Variables: map[string]float64{"x": 5, "a": 100}}
+	cases := []struct {
+		script      string
+		assignments string
+		result      string
+		err         error
+	}{
+		{"a = 3; b = a*2; a+b", "a=3 b=6", "9.000", nil},
+		{"a = x*2; b = a+1; c = a+2;", "a=10 b=11 c=12", "12.000", nil},
+		{"a = a+1; a = a*2; a", "a=101 a=202", "202.000", nil},
+		{"big = x > 3; if(big, x, 0)", "big=true", "5.000", nil},
+		{"x*2", "", "10.000", nil},
+		{"a = 1; 2; 3", "", "", ErrInvalidScript},
+		{"pi = 3; pi", "", "", ErrInvalidVariable},
+		{"b = c; c = 1", "", "", ErrUnknownVariable},
+		{"a = (1; a", "", "", ErrMissingBracket},
+		{" ; ", "", "", ErrEmptyExpression},
+	}
+	for _, c := range cases {
+		result, err := ResumeScript(context.Background(), c.script, "1", scope, nil, taskChan, results)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.script, c.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var assignments []string
+		for _, assignment := range result.Assignments {
+			assignments = append(assignments, assignment.Name+"="+assignment.Value.String())
+		}
+		if strings.Join(assignments, " ") != c.assignments || FormatResult(result.Result) != c.result {
+			t.Errorf("%s: expected %s and %s, got %v and %s", c.script, c.assignments, c.result, assignments, FormatResult(result.Result))
+		}
+	}
+
+	var parseErr *ParseError
+	if _, err := ParseScript("a = 1;\n2 + 3;\nb = 4"); !errors.As(err, &parseErr) || parseErr.Pos.Line != 2 || parseErr.Token != "2" {
+		t.Errorf("expected ParseError at 2, got %v", err)
+	}
+	if _, err := Parse("a = 1; a"); err == nil {
+		t.Errorf("expected an expression not to accept a script")
+	}
+	variables, err := UsedVariables("b = a + x; a = b; a", scope.Variables)
+	if err != nil || len(variables) != 2 {
+		t.Errorf("expected a and x, got %v, %v", variables, err)
+	}
+	if variables, _ := UsedVariables("a = 1; a + x", scope.Variables); len(variables) != 1 {
+		t.Errorf("expected only x, got %v", variables)
+	}
+}
+
+func TestCalcScriptSharesOperations(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+
+	// Присваивания b и c зависят от a, но a вычисляется один раз, а b и c -
+	// одновременно.
+	go func() {
+		first := <-taskChan
+		if first.Operation != "*" {
+			t.Errorf("expected x*2 first, got %v", first)
+		}
+		results <- contract.TaskResult{ID: first.ID, Result: execute(first)}
+		second, third := <-taskChan, <-taskChan
+		results <- contract.TaskResult{ID: second.ID, Result: execute(second)}
+		results <- contract.TaskResult{ID: third.ID, Result: execute(third)}
+		last := <-taskChan
+		results <- contract.TaskResult{ID: last.ID, Result: execute(last)}
+	}()
+
+	scope := Scope{Variables: map[string]float64{"x": 5}}
+	result, err := ResumeScript(context.Background(), "a = x*2; b = a+1; c = a-1; b*c", "1", scope, nil, taskChan, results)
+	if err != nil || result.Result.Float64() != 99 || len(result.Assignments) != 3 {
+		t.Fatalf("expected 99 and 3 assignments, got %+v, %v", result, err)
+	}
+
+	// После перезапуска a (операция 0) уже вычислено.
+	go func() {
+		for i := 0; i < 3; i++ {
+			task := <-taskChan
+			if task.Operation == "*" && task.Arg2 == 2 {
+				t.Errorf("finished operation was dispatched again")
+			}
+			results <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+		}
+	}()
+	result, err = ResumeScript(context.Background(), "a = x*2; b = a+1; c = a-1; b*c", "1", scope, map[int]string{0: "10"}, taskChan, results)
+	if err != nil || result.Result.Float64() != 99 || result.Assignments[0].Value.Float64() != 10 {
+		t.Errorf("expected 99 with a = 10, got %+v, %v", result, err)
+	}
+}
+
+func TestCalcVariables(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	runAgent(t, taskChan, results)
+
+	variables, err := UsedVariables("price * (1 + rate) + pi", map[string]float64{"price": 100, "rate": 0.5, "unused": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(variables) != 2 || variables["price"] != 100 || variables["rate"] != 0.5 {
+		t.Fatalf("unexpected variables: %v", variables)
+	}
+
+	result, err := Resume(context.Background(), "price * (1 + rate) + pi", "1", Scope{Variables: variables}, nil, taskChan, results)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Float64() != 150+math.Pi {
+		t.Errorf("expected %f, got %s", 150+math.Pi, result)
+	}
+}
+
+func TestCheckVariableName(t *testing.T) {
+	for _, name := range []string{"rate", "x_1", "Price"} {
+		if err := CheckVariableName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1x", "a b", "rate+1", "pi", "e", "sqrt"} {
+		if err := CheckVariableName(name); err != ErrInvalidVariable {
+			t.Errorf("%q: expected ErrInvalidVariable, got %v", name, err)
+		}
+	}
+}
+
+func TestCalcUserFunctions(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	results := make(chan contract.TaskResult)
+	taskChan := make(chan contract.TaskData, 10)
+	runAgent(t, taskChan, results)
+
+	functions := map[string]string{
+		"f": "f(x, y) = x^2 + 2*x*y",
+		"g": "g(a) = f(a, 1) + sqrt(a)",
+		"h": "h(x) = k(x)",
+		"k": "k(x) = h(x) + 1",
+	}
+	used, err := UsedFunctions("g(4)", functions)
+	if err != nil || len(used) != 2 || used["f"] == "" || used["g"] == "" {
+		t.Fatalf("unexpected used functions: %v, %v", used, err)
+	}
+	scope := Scope{Functions: used}
+
+	cases := []struct {
+		expression string
+		result     float64
+	}{
+		{"f(2, 3) + g(4)", 42},
+		{"f(f(1, 1), 1)", 15},
+	}
+	for _, c := range cases {
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expression, err)
+			continue
+		}
+		if result.Float64() != c.result {
+			t.Errorf("%s: expected %f, got %s", c.expression, c.result, result)
+		}
+	}
+
+	if _, err := Resume(context.Background(), "f(1)", "1", scope, nil, taskChan, results); !errors.Is(err, ErrArgumentCount) {
+		t.Errorf("expected ErrArgumentCount, got %v", err)
+	}
+	if _, err := Resume(context.Background(), "h(1)", "1", Scope{Functions: functions}, nil, taskChan, results); !errors.Is(err, ErrRecursion) {
+		t.Errorf("expected ErrRecursion, got %v", err)
+	}
+}
+
+func TestUserFunctionArgumentsBuiltOnce(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	const depth = 20
+	expression := strings.Repeat("sq(", depth) + "y" + strings.Repeat(")", depth)
+	scope := Scope{
+		Variables: map[string]float64{"y": 2, "x": 10},
+		Functions: map[string]string{"sq": "sq(x) = x*x", "first": "first(a, b) = a"},
+	}
+
+	// Каждый уровень - одна операция, а не копия аргумента на каждое
+	// упоминание параметра.
+	plan, err := Validate(expression, scope)
+	if err != nil || plan.Tasks != depth {
+		t.Fatalf("expected %d tasks, got %d, %v", depth, plan.Tasks, err)
+	}
+	// Аргумент неиспользуемого параметра не строится.
+	if plan, err := Validate("first(x, 1/0)", scope); err != nil || plan.Tasks != 0 {
+		t.Errorf("expected unused argument to be skipped, got %+v, %v", plan, err)
+	}
+
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+	// x в аргументе - переменная вызывающего выражения, а не параметр sq.
+	result, err := Resume(context.Background(), "sq(sq(x + 1))", "1", scope, nil, taskChan, results)
+	if err != nil || result.Float64() != 14641 {
+		t.Errorf("expected 14641, got %v, %v", result, err)
+	}
+}
+
+func TestCheckFunction(t *testing.T) {
+	functions := map[string]string{
+		"f": "f(x, y) = x * y",
+		"g": "g(x) = f(x, 1) + 1",
+	}
+	cases := []struct {
+		definition string
+		err        error
+	}{
+		{"area(r) = pi * r^2", nil},
+		{"g(x) = f(x, 2) - 1", nil},
+		{"f(x, y) = g(x) + y", ErrRecursion},
+		{"f(x) = f(x - 1)", ErrRecursion},
+		{"h(x) = f(x)", ErrArgumentCount},
+		{"h(x) = sqrt(x, 2)", ErrArgumentCount},
+		{"h(x) = x + y", ErrUnknownVariable},
+		{"h(x) = unknown(x)", ErrUnknownFunction},
+		{"h(x, x) = x", ErrInvalidFunction},
+		{"sqrt(x) = x", ErrInvalidFunction},
+		{"h(x) x + 1", ErrInvalidFunction},
+		{"h(x) = ", ErrInvalidFunction},
+	}
+	for _, c := range cases {
+		function, err := ParseFunction(c.definition)
+		if err == nil {
+			err = CheckFunction(function, functions)
+		}
+		if c.err == nil && err != nil || !errors.Is(err, c.err) {
+			t.Errorf("%q: expected %v, got %v", c.definition, c.err, err)
+		}
+	}
+}
+
+func TestCalcDecimal(t *testing.T) {
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Precision: PrecisionDecimal, Variables: map[string]float64{"rate": 0.1}}
+	cases := []struct {
+		expression string
+		result     string
+	}{
+		{"0.1 + 0.2", "0.3"},
+		{"123456789.123456789 * 1000", "123456789123.456789"},
+		{"rate * 3 - 0.3", "0"},
+		{"-(1 - 3) / 8", "0.25"},
+		{"1 / 3", "0.3333333333333333333333333333333333"},
+		{"- -5", "5"},
+	}
+	for _, c := range cases {
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.expression, err)
+			continue
+		}
+		if FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.result, FormatResult(result))
+		}
+	}
+}
+
+func TestCalcDecimalRequiresExactResult(t *testing.T) {
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	go func() {
+		task := <-taskChan
+		results <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+	}()
+
+	_, err := Resume(context.Background(), "0.1 + 0.2", "1", Scope{Precision: PrecisionDecimal}, nil, taskChan, results)
+	if !errors.Is(err, ErrInvalidResult) {
+		t.Errorf("expected %v, got %v", ErrInvalidResult, err)
+	}
+}
+
+// Те же значения проверяет TestFormatDecimal агента: обе копии
+// formatDecimal должны записывать дроби одинаково.
+func TestFormatDecimal(t *testing.T) {
+	cases := []struct {
+		rat    string
+		result string
+	}{
+		{"5", "5"},
+		{"-7/2", "-3.5"},
+		{"3/10", "0.3"},
+		{"1/1024", "0.0009765625"},
+		{"1/100000000000000000000000000000000000000000", "0.00000000000000000000000000000000000000001"},
+		{"1/3", "0.3333333333333333333333333333333333"},
+		{"2/3", "0.6666666666666666666666666666666667"},
+		{"-1/7", "-0.1428571428571428571428571428571429"},
+		{"1/6", "0.1666666666666666666666666666666667"},
+		{"-1/300000000000000000000000000000000000000", "0"},
+		{"1/2999999999999999999999999999999999", "0.0000000000000000000000000000000003"},
+	}
+	for _, c := range cases {
+		rat, _ := new(big.Rat).SetString(c.rat)
+		if result := formatDecimal(rat); result != c.result {
+			t.Errorf("%s: expected %s, got %s", c.rat, c.result, result)
+		}
+	}
+}
+
+func TestCalcRational(t *testing.T) {
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Precision: PrecisionRational}
+	cases := []struct {
+		expression    string
+		result        string
+		approximation string
+	}{
+		{"1/3 + 1/6", "1/2", "0.5"},
+		{"0.5 + 1/3", "5/6", "0.8333333333333333333333333333333333"},
+		{"-(2/4) * 3", "-3/2", "-1.5"},
+		{"6 / 3", "2", "2"},
+	}
+	for _, c := range cases {
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.expression, err)
+			continue
+		}
+		if FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.result, FormatResult(result))
+		}
+		if approximation := PrecisionRational.Approximate(FormatResult(result)); approximation != c.approximation {
+			t.Errorf("%s: expected approximation %s, got %s", c.expression, c.approximation, approximation)
+		}
+	}
+
+	// Продолжение вычисления: результаты из журнала записаны дробями.
+	result, err := Resume(context.Background(), "(1/3 + 1/6) * 3", "1", scope, map[int]string{0: "1/3"}, taskChan, results)
+	if err != nil || result.String() != "3/2" {
+		t.Errorf("expected 3/2, got %v, %v", result, err)
+	}
+}
+
+func TestValidateExactDomains(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	for _, precision := range []Precision{PrecisionDecimal, PrecisionRational} {
+		scope := Scope{Precision: precision}
+		for expression, expected := range map[string]error{
+			"sqrt(-2)":      ErrInvalidArgument,
+			"log(0)":        ErrInvalidArgument,
+			"log(2, 1)":     ErrInvalidArgument,
+			"(-8)^0.5":      ErrInvalidArgument,
+			"10^2000":       ErrInvalidArgument,
+			"0^(-1)":        ErrNullDivision,
+			"2^-2":          nil,
+			"(-8)^3":        nil,
+			"0.5^3000":      nil,
+			"log(0.1^400)":  nil,
+			"sqrt(0.1^400)": nil,
+		} {
+			if _, err := Validate(expression, scope); !errors.Is(err, expected) {
+				t.Errorf("%s %s: expected %v, got %v", precision, expression, expected, err)
+			}
+		}
+	}
+}
+
+func TestCalcInteger(t *testing.T) {
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Precision: PrecisionInteger, Variables: map[string]float64{"mask": 15, "rate": 0.5}}
+	cases := []struct {
+		expression string
+		result     string
+		err        error
+	}{
+		{"0xFF & (1<<4) | 0b1010", "26", nil},
+		{"~0 & mask", "15", nil},
+		{"-0x10 >> 2", "-4", nil},
+		{"9223372036854775807", "9223372036854775807", nil},
+		{"-9223372036854775808", "-9223372036854775808", nil},
+		{"9223372036854775807 + 1", "", ErrIntegerOverflow},
+		{"(1 << 63) - 1", "", ErrIntegerOverflow},
+		{"1 << -1", "", ErrInvalidArgument},
+		{"1.5 + 1", "", ErrNotInteger},
+		{"rate * 2", "", ErrNotInteger},
+		{"pi", "", ErrNotInteger},
+		{"sqrt(4)", "", ErrUnsupported},
+	}
+	for _, c := range cases {
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.expression, c.err, err)
+			continue
+		}
+		if err == nil && FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.result, FormatResult(result))
+		}
+	}
+
+	if _, err := Calc(context.Background(), "6 | 3", "1", taskChan, results); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected %v in float mode, got %v", ErrUnsupported, err)
+	}
+	result, err := Calc(context.Background(), "0x10 + 0b1 + 0o7", "1", taskChan, results)
+	if err != nil || result.Float64() != 24 {
+		t.Errorf("expected 24 in float mode, got %v, %v", result, err)
+	}
+}
+
+func TestPrecisionHex(t *testing.T) {
+	cases := map[string]string{
+		"26":      "0x1a",
+		"0":       "0x0",
+		"-1":      "0xffffffffffffffff",
+		"UNKNOWN": "",
+		"12.5":    "",
+	}
+	for result, hex := range cases {
+		if got := PrecisionInteger.Hex(result); got != hex {
+			t.Errorf("%s: expected %q, got %q", result, hex, got)
+		}
+	}
+	if got := PrecisionFloat.Hex("26"); got != "" {
+		t.Errorf("expected no hex in float mode, got %q", got)
+	}
+}
+
+func TestCalcComplex(t *testing.T) {
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	scope := Scope{Precision: PrecisionComplex, Variables: map[string]float64{"x": 2}}
+	cases := []struct {
+		expression string
+		result     string
+		err        error
+	}{
+		{"(3+4i)*(1-2i)", "11-2i", nil},
+		{"sqrt(-1)", "1i", nil},
+		{"-4i", "-4i", nil},
+		{"x * 2.5i - x", "-2+5i", nil},
+		{"(1+1i) / (1-1i)", "1i", nil},
+		{"(1i - 1i) + 3", "3", nil},
+		{"1 / (2i - 2i)", "", ErrNullDivision},
+		{"1 / 0i", "", ErrNullDivision},
+		{"log(0i)", "", ErrInvalidArgument},
+		{"5 % 2", "", ErrUnsupported},
+		{"max(1, 2i)", "", ErrUnsupported},
+		{"1 & 2", "", ErrUnsupported},
+	}
+	for _, c := range cases {
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.expression, c.err, err)
+			continue
+		}
+		if err == nil && FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.result, FormatResult(result))
+		}
+	}
+
+	if _, err := Calc(context.Background(), "2 * 4i", "1", taskChan, results); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected %v in float mode, got %v", ErrUnsupported, err)
+	}
+	if value := PrecisionComplex.Complex("11-2i"); value == nil || *value != (contract.Complex{Real: 11, Imag: -2}) {
+		t.Errorf("expected 11 and -2, got %v", value)
+	}
+	if value := PrecisionFloat.Complex("11"); value != nil {
+		t.Errorf("expected no complex parts in float mode, got %v", value)
+	}
+}
+
+// Те же значения проверяет TestFormatComplex агента: обе копии
+// formatComplex должны записывать числа одинаково.
+func TestFormatComplex(t *testing.T) {
+	cases := []struct {
+		value  complex128
+		result string
+	}{
+		{0, "0"},
+		{complex(math.Copysign(0, -1), 0), "0"},
+		{5, "5"},
+		{-2.5, "-2.5"},
+		{1i, "1i"},
+		{-1i, "-1i"},
+		{11 - 2i, "11-2i"},
+		{-1.5 + 0.25i, "-1.5+0.25i"},
+		{1e21 + 1e-7i, "1e+21+1e-07i"},
+	}
+	for _, c := range cases {
+		if result := formatComplex(c.value); result != c.result {
+			t.Errorf("%v: expected %s, got %s", c.value, c.result, result)
+		}
+		if value, err := strconv.ParseComplex(c.result, 128); err != nil || value != c.value {
+			t.Errorf("%s: round trip gave %v, %v", c.result, value, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	contract.AppConfig = &contract.Config{TIME_ADDITION_MS: 100, TIME_MULTIPLICATIONS_MS: 300, TIME_FUNCTIONS_MS: map[string]int{"sqrt": 50}}
+	scope := Scope{Variables: map[string]float64{"x": 2}, Functions: map[string]string{"sq": "sq(a) = a*a"}}
+
+	plan, err := Validate("(1+2)*(3+4) + sqrt(sq(x))", scope)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Две суммы выполняются одновременно: 100 + 300 + 100 мс, а цепочка
+	// sq, sqrt занимает 300 + 50 мс.
+	if plan.Tasks != 6 || plan.EstimatedMs != 500 {
+		t.Errorf("expected 6 tasks and 500 ms, got %d and %d", plan.Tasks, plan.EstimatedMs)
+	}
+	if plan.Canonical != "(1 + 2) * (3 + 4) + sqrt(sq(x))" || plan.AST.Type != "binary" || plan.AST.Op != "+" {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	if plan, err := Validate("-5", Scope{}); err != nil || plan.Tasks != 0 || plan.EstimatedMs != 0 {
+		t.Errorf("expected no tasks for a constant, got %+v, %v", plan, err)
+	}
+	for expression, expected := range map[string]error{
+		"1 / 0":    ErrNullDivision,
+		"sqrt(-1)": ErrInvalidArgument,
+		"y + 1":    ErrUnknownVariable,
+		"1 +":      ErrInvalidExpression,
+	} {
+		if _, err := Validate(expression, scope); !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v, got %v", expression, expected, err)
+		}
+	}
+}
+
+func TestCalcOptimize(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+
+	cases := []struct {
+		precision  Precision
+		expression string
+		result     string
+		tasks      int
+		saved      int
+	}{
+		{PrecisionFloat, "2 + 3*4", "14.000", 0, 2},
+		// Третья сумма - подвыражение из трех операций, больше FoldLimit.
+		{PrecisionFloat, "1 + 2 + 3 + 4", "10.000", 1, 2},
+		{PrecisionFloat, "sqrt(16)*1 + 0", "4.000", 1, 2},
+		{PrecisionFloat, "sqrt(16) + sqrt(16)", "8.000", 2, 1},
+		// В режиме float x*0 не упрощается: x может оказаться бесконечностью.
+		{PrecisionFloat, "sqrt(16) * 0", "0.000", 2, 0},
+		{PrecisionDecimal, "(0.1 + 0.2 + 0.3 + 0.4) * 0", "0", 0, 4},
+		{PrecisionDecimal, "1 / 3", "0.3333333333333333333333333333333333", 0, 1},
+		{PrecisionRational, "1/3 + 1/3 + 1/3", "1", 2, 3},
+		{PrecisionInteger, "9223372036854775807 + 1 - 1", "", 2, 0},
+	}
+	for _, c := range cases {
+		scope := Scope{Precision: c.precision, Optimize: true, FoldLimit: 2}
+		plan, err := Validate(c.expression, scope)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expression, err)
+			continue
+		}
+		if plan.Tasks != c.tasks || plan.SavedTasks != c.saved {
+			t.Errorf("%s: expected %d tasks and %d saved, got %d and %d", c.expression, c.tasks, c.saved, plan.Tasks, plan.SavedTasks)
+		}
+		if c.result == "" {
+			continue
+		}
+		result, err := Resume(context.Background(), c.expression, "1", scope, nil, taskChan, results)
+		if err != nil || FormatResult(result) != c.result {
+			t.Errorf("%s: expected %s, got %v, %v", c.expression, c.result, result, err)
+		}
+	}
+
+	// Операции, которые не проходят проверку, не упрощаются.
+	scope := Scope{Optimize: true, FoldLimit: 10}
+	if _, err := Resume(context.Background(), "1/0 + 1", "1", scope, nil, taskChan, results); !errors.Is(err, ErrNullDivision) {
+		t.Errorf("expected %v, got %v", ErrNullDivision, err)
+	}
+	if saved, err := SavedTasks("1 + 2", Scope{}); err != nil || saved != 0 {
+		t.Errorf("expected no saved tasks without optimize, got %d, %v", saved, err)
+	}
+}
+
+func TestOperationCache(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	Cache = NewOperationCache(10, time.Minute)
+	t.Cleanup(func() { Cache = NewOperationCache(0, 0) })
+	taskChan := make(chan contract.TaskData, 10)
+	results := make(chan contract.TaskResult)
+	runAgent(t, taskChan, results)
+	scope := Scope{Cache: true}
+
+	for i := 0; i < 2; i++ {
+		result, err := Resume(context.Background(), "sqrt(16) + 1", "1", scope, nil, taskChan, results)
+		if err != nil || result.Float64() != 5 {
+			t.Fatalf("expected 5, got %v, %v", result, err)
+		}
+	}
+	// Во второй раз обе операции взяты из кэша.
+	if stats := Cache.Stats(); stats != (CacheStats{Size: 2, Hits: 2, Misses: 2}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	// Без Cache кэш не используется, даже если выражение упрощается.
+	if _, err := Resume(context.Background(), "sqrt(16) + 1", "1", Scope{Optimize: true}, nil, taskChan, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats := Cache.Stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("expected cache to be skipped, got %+v", stats)
+	}
+}
+
+func TestOperationCacheCoalescesPendingTasks(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	Cache = NewOperationCache(10, time.Minute)
+	t.Cleanup(func() { Cache = NewOperationCache(0, 0) })
+	taskChan := make(chan contract.TaskData, 10)
+	scope := Scope{Cache: true}
+
+	start := func(expression string, id string, results chan contract.TaskResult) chan Number {
+		done := make(chan Number, 1)
+		go func() {
+			result, err := Resume(context.Background(), expression, id, scope, nil, taskChan, results)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+			done <- result
+		}()
+		return done
+	}
+	first, second := make(chan contract.TaskResult), make(chan contract.TaskResult)
+	firstDone := start("sqrt(25)", "1", first)
+	task := <-taskChan
+	secondDone := start("sqrt(25)", "2", second)
+	for Cache.Stats().Coalesced == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	first <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+	if a, b := <-firstDone, <-secondDone; a.Float64() != 5 || b.Float64() != 5 {
+		t.Errorf("expected 5 and 5, got %v and %v", a, b)
+	}
+	if len(taskChan) != 0 {
+		t.Errorf("expected one task, got %d more", len(taskChan))
+	}
+
+	// Ответ нейросети не кэшируется, а ожидающее выражение отправляет
+	// задачу само.
+	firstDone = start("sqrt(36)", "3", first)
+	task = <-taskChan
+	secondDone = start("sqrt(36)", "4", second)
+	for Cache.Stats().Coalesced == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	first <- contract.TaskResult{ID: task.ID, Result: execute(task), AI: true}
+	task = <-taskChan
+	second <- contract.TaskResult{ID: task.ID, Result: execute(task)}
+	if a, b := <-firstDone, <-secondDone; a.Float64() != 6 || b.Float64() != 6 {
+		t.Errorf("expected 6 and 6, got %v and %v", a, b)
+	}
+	if stats := Cache.Stats(); stats.Size != 2 {
+		t.Errorf("expected only agent results in cache, got %+v", stats)
+	}
+}
+
+func TestOperationCacheSkipsNondeterministic(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	Cache = NewOperationCache(10, time.Minute)
+	Functions.Register(Function{Name: "noise", MinArgs: 1, MaxArgs: 1, Nondeterministic: true})
+	t.Cleanup(func() {
+		Cache = NewOperationCache(0, 0)
+		Functions = builtinFunctions()
+	})
+	taskChan := make(chan contract.TaskData, 10)
+	scope := Scope{Cache: true}
+
+	// Одновременные одинаковые вызовы не объединяются: агенты получают
+	// обе задачи, и каждое выражение получает свой результат.
+	first, second := make(chan contract.TaskResult), make(chan contract.TaskResult)
+	firstDone, secondDone := make(chan Number, 1), make(chan Number, 1)
+	for _, run := range []struct {
+		id      string
+		results chan contract.TaskResult
+		done    chan Number
+	}{{"1", first, firstDone}, {"2", second, secondDone}} {
+		go func() {
+			result, err := Resume(context.Background(), "noise(1)", run.id, scope, nil, taskChan, run.results)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", run.id, err)
+			}
+			run.done <- result
+		}()
+	}
+	tasks := map[int]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case task := <-taskChan:
+			tasks[task.ID] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected two tasks, got %d", len(tasks))
+		}
+	}
+	for id := range tasks {
+		result := contract.TaskResult{ID: id, Result: float64(id)}
+		if task, _ := Tasks.Get(id); task.ExpressionID == "1" {
+			first <- result
+		} else {
+			second <- result
+		}
+	}
+	if a, b := <-firstDone, <-secondDone; a.Float64() == b.Float64() {
+		t.Errorf("expected different results, got %v and %v", a, b)
+	}
+
+	// Результат не сохраняется в кэше.
+	done := make(chan Number, 1)
+	go func() {
+		result, _ := Resume(context.Background(), "noise(1)", "3", scope, nil, taskChan, first)
+		done <- result
+	}()
+	task := <-taskChan
+	first <- contract.TaskResult{ID: task.ID, Result: 7}
+	if result := <-done; result.Float64() != 7 {
+		t.Errorf("expected a new result 7, got %v", result)
+	}
+	if stats := Cache.Stats(); stats != (CacheStats{}) {
+		t.Errorf("expected cache to be skipped, got %+v", stats)
+	}
+}
+
+func TestOptimizeSkipsNondeterministic(t *testing.T) {
+	contract.AppConfig = &contract.Config{}
+	Functions.Register(Function{Name: "noise", MinArgs: 1, MaxArgs: 1, Nondeterministic: true})
+	t.Cleanup(func() { Functions = builtinFunctions() })
+
+	// Одинаковые вызовы noise не объединяются, а одинаковые sqrt - да.
+	plan, err := Validate("noise(1) + noise(1) + sqrt(2) + sqrt(2)", Scope{Optimize: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Tasks != 6 || plan.SavedTasks != 1 {
+		t.Errorf("expected 6 tasks and 1 saved, got %d and %d", plan.Tasks, plan.SavedTasks)
+	}
+}
+
+func TestOperationCacheLimits(t *testing.T) {
+	cache := NewOperationCache(1, time.Minute)
+	for _, key := range []string{"a", "b"} {
+		if _, status := cache.acquire(key, nil); status != cacheMiss {
+			t.Fatalf("%s: expected miss, got %v", key, status)
+		}
+		cache.release(key, floatNumber(1), "", true)
+	}
+	if _, status := cache.acquire("a", nil); status != cacheMiss {
+		t.Errorf("expected the oldest result to be evicted, got %v", status)
+	}
+	if _, status := cache.acquire("b", nil); status != cacheHit {
+		t.Errorf("expected hit, got %v", status)
+	}
+
+	cache = NewOperationCache(1, time.Millisecond)
+	cache.acquire("a", nil)
+	cache.release("a", floatNumber(1), "", true)
+	time.Sleep(5 * time.Millisecond)
+	if _, status := cache.acquire("a", nil); status != cacheMiss {
+		t.Errorf("expected expired result to be dropped, got %v", status)
+	}
+}
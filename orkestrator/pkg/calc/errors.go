@@ -1,6 +1,11 @@
 package calc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
 
 var (
 	ErrInvalidExpression = errors.New("неправильное выражение")
@@ -10,4 +15,110 @@ var (
 	ErrEmptyExpression   = errors.New("пустое выражение")
 	ErrNotFound          = errors.New("не найдено выражение")
 	ErrNotTask           = errors.New("нет доступных задач")
+	ErrUnknownTask       = errors.New("неизвестная задача")
+	ErrTaskFinished      = errors.New("задача уже выполнена")
+	ErrTaskLeaseExpired  = errors.New("агенты не вернули результат задачи за отведенное число попыток")
+	ErrInvalidTransition = errors.New("недопустимая смена статуса выражения")
+	ErrCancelled         = errors.New("вычисление выражения отменено")
+	ErrDeadlineExceeded  = errors.New("истек срок вычисления выражения")
+	ErrInvalidDeadline   = errors.New("неправильный срок вычисления выражения")
+	ErrUnknownFunction   = errors.New("неизвестная функция")
+	ErrArgumentCount     = errors.New("неправильное число аргументов функции")
+	ErrInvalidArgument   = errors.New("недопустимый аргумент функции")
+	ErrUnknownVariable   = errors.New("неизвестная переменная")
+	ErrInvalidVariable   = errors.New("неправильное имя переменной")
+	ErrVariableNotFound  = errors.New("не найдена переменная")
+	ErrInvalidFunction   = errors.New("неправильное определение функции")
+	ErrRecursion         = errors.New("рекурсивный вызов функции")
+	ErrFunctionNotFound  = errors.New("не найдена функция")
+	ErrInvalidPrecision  = errors.New("неизвестный режим вычисления")
+	ErrInvalidResult     = errors.New("агент вернул неправильный результат")
+	ErrIntegerOverflow   = errors.New("переполнение 64-битного целого числа")
+	ErrNotInteger        = errors.New("в режиме integer допустимы только целые числа")
+	ErrUnsupported       = errors.New("операция недоступна в этом режиме вычисления")
+	ErrTypeMismatch      = errors.New("аргумент операции имеет неподходящий тип")
+	ErrInvalidScript     = errors.New("неправильный сценарий")
+	ErrTaskFailed        = errors.New("агент не смог выполнить задачу")
 )
+
+// Коды ошибок не меняются вместе с текстом сообщений.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidExpression, "INVALID_EXPRESSION"},
+	{ErrNullDivision, "DIVISION_BY_ZERO"},
+	{ErrIllegalSign, "ILLEGAL_SIGN"},
+	{ErrMissingBracket, "MISSING_BRACKET"},
+	{ErrEmptyExpression, "EMPTY_EXPRESSION"},
+	{ErrNotFound, "EXPRESSION_NOT_FOUND"},
+	{ErrNotTask, "NO_TASKS"},
+	{ErrUnknownTask, "UNKNOWN_TASK"},
+	{ErrTaskFinished, "TASK_FINISHED"},
+	{ErrTaskLeaseExpired, "TASK_LEASE_EXPIRED"},
+	{ErrInvalidTransition, "INVALID_TRANSITION"},
+	{ErrCancelled, "CANCELLED"},
+	{ErrDeadlineExceeded, "DEADLINE_EXCEEDED"},
+	{ErrInvalidDeadline, "INVALID_DEADLINE"},
+	{ErrUnknownFunction, "UNKNOWN_FUNCTION"},
+	{ErrArgumentCount, "INVALID_ARGUMENT_COUNT"},
+	{ErrInvalidArgument, "INVALID_ARGUMENT"},
+	{ErrUnknownVariable, "UNKNOWN_VARIABLE"},
+	{ErrInvalidVariable, "INVALID_VARIABLE"},
+	{ErrVariableNotFound, "VARIABLE_NOT_FOUND"},
+	{ErrInvalidFunction, "INVALID_FUNCTION"},
+	{ErrRecursion, "RECURSIVE_FUNCTION"},
+	{ErrFunctionNotFound, "FUNCTION_NOT_FOUND"},
+	{ErrInvalidPrecision, "INVALID_PRECISION"},
+	{ErrInvalidResult, "INVALID_RESULT"},
+	{ErrIntegerOverflow, "INTEGER_OVERFLOW"},
+	{ErrNotInteger, "NOT_INTEGER"},
+	{ErrUnsupported, "UNSUPPORTED_OPERATION"},
+	{ErrTypeMismatch, "TYPE_MISMATCH"},
+	{ErrInvalidScript, "INVALID_SCRIPT"},
+	{ErrTaskFailed, "TASK_FAILED"},
+}
+
+// ErrorCode возвращает код ошибки err или "INTERNAL_ERROR" для неизвестных ошибок.
+func ErrorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return "INTERNAL_ERROR"
+}
+
+// ParseError - ошибка разбора выражения вместе с местом, где она найдена.
+type ParseError struct {
+	// Err - причина ошибки, например ErrIllegalSign или ErrMissingBracket.
+	Err   error
+	Pos   Pos
+	Token string
+	// Expression - текст выражения, в котором найдена ошибка.
+	Expression string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: строка %d, столбец %d", e.Err, e.Pos.Line, e.Pos.Column)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Snippet возвращает строку выражения со стрелкой под местом ошибки.
+func (e *ParseError) Snippet() string {
+	lines := strings.Split(e.Expression, "\n")
+	if e.Pos.Line < 1 || e.Pos.Line > len(lines) {
+		return ""
+	}
+	line := []rune(strings.TrimSuffix(lines[e.Pos.Line-1], "\r"))
+	indent := make([]rune, 0, e.Pos.Column)
+	for _, r := range line[:min(e.Pos.Column-1, len(line))] {
+		if r != '\t' {
+			r = ' '
+		}
+		indent = append(indent, r)
+	}
+	width := max(utf8.RuneCountInString(e.Token), 1)
+	return string(line) + "\n" + string(indent) + strings.Repeat("^", width)
+}
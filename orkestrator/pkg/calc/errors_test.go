@@ -0,0 +1,44 @@
+package calc
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// TestErrorCodes проверяет, что у каждой ошибки из errors.go есть свой код.
+func TestErrorCodes(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+			for _, spec := range decl.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if name.Name != "errorCodes" {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	if len(names) != len(errorCodes) {
+		t.Errorf("expected a code for each of %d errors, got %d codes", len(names), len(errorCodes))
+	}
+
+	codes := make(map[string]bool)
+	errs := make(map[error]bool)
+	for _, e := range errorCodes {
+		if codes[e.code] || errs[e.err] {
+			t.Errorf("duplicate error code %s", e.code)
+		}
+		codes[e.code] = true
+		errs[e.err] = true
+	}
+	if code := ErrorCode(&ParseError{Err: ErrMissingBracket}); code != "MISSING_BRACKET" {
+		t.Errorf("expected MISSING_BRACKET for a parse error, got %s", code)
+	}
+}
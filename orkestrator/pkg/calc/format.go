@@ -0,0 +1,99 @@
+package calc
+
+import "strings"
+
+// Format записывает выражение в каноническом виде, со скобками только там, где они нужны.
+func Format(expr Expr) string {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		return e.Text
+	case *IdentExpr:
+		return e.Name
+	case *UnaryExpr:
+		return e.Op + formatOperand(e.Operand, precedence(e.Operand) < unaryPrecedence)
+	case *BinaryExpr:
+		p := binaryPrecedence[e.Op]
+		left, right := precedence(e.Left), precedence(e.Right)
+		leftParens := left < p || (left == p && rightAssociative[e.Op])
+		rightParens := right < p || (right == p && !rightAssociative[e.Op])
+		return formatOperand(e.Left, leftParens) + " " + e.Op + " " + formatOperand(e.Right, rightParens)
+	case *CallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = Format(arg)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *ScriptExpr:
+		statements := make([]string, len(e.Statements))
+		for i, statement := range e.Statements {
+			statements[i] = Format(statement.Expr)
+			if statement.Name != "" {
+				statements[i] = statement.Name + " = " + statements[i]
+			}
+		}
+		return strings.Join(statements, "; ")
+	}
+	return ""
+}
+
+func formatOperand(expr Expr, parens bool) string {
+	if parens {
+		return "(" + Format(expr) + ")"
+	}
+	return Format(expr)
+}
+
+func precedence(expr Expr) int {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		return binaryPrecedence[e.Op]
+	case *UnaryExpr:
+		return unaryPrecedence
+	case *NumberExpr:
+		if strings.HasPrefix(e.Text, "-") {
+			return unaryPrecedence
+		}
+	}
+	// Числа, имена и вызовы функций никогда не берутся в скобки.
+	return 100
+}
+
+// Node - узел синтаксического дерева в виде, который удобно отдавать в JSON.
+type Node struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+	// Op - знак унарной или бинарной операции.
+	Op       string `json:"op,omitempty"`
+	Operands []Node `json:"operands,omitempty"`
+	Pos      Pos    `json:"position"`
+}
+
+// Tree переводит синтаксическое дерево выражения в узлы Node.
+func Tree(expr Expr) Node {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		return Node{Type: "number", Value: e.Text, Pos: e.Pos}
+	case *IdentExpr:
+		return Node{Type: "variable", Value: e.Name, Pos: e.Pos}
+	case *UnaryExpr:
+		return Node{Type: "unary", Op: e.Op, Operands: []Node{Tree(e.Operand)}, Pos: e.Pos}
+	case *BinaryExpr:
+		return Node{Type: "binary", Op: e.Op, Operands: []Node{Tree(e.Left), Tree(e.Right)}, Pos: e.Pos}
+	case *CallExpr:
+		operands := make([]Node, len(e.Args))
+		for i, arg := range e.Args {
+			operands[i] = Tree(arg)
+		}
+		return Node{Type: "call", Value: e.Name, Operands: operands, Pos: e.Pos}
+	case *ScriptExpr:
+		operands := make([]Node, len(e.Statements))
+		for i, statement := range e.Statements {
+			operands[i] = Tree(statement.Expr)
+			if statement.Name != "" {
+				operands[i] = Node{Type: "assignment", Value: statement.Name, Operands: []Node{operands[i]}, Pos: statement.Pos}
+			}
+		}
+		return Node{Type: "script", Operands: operands, Pos: e.Pos}
+	}
+	return Node{}
+}
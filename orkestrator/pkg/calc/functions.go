@@ -0,0 +1,106 @@
+package calc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type Function struct {
+	Name    string
+	MinArgs int
+	// MaxArgs равен -1, если число аргументов не ограничено.
+	MaxArgs int
+	Check   func(args []float64) error
+	// Результат Nondeterministic функции не кэшируется, а ее вызовы не объединяются.
+	Nondeterministic bool
+}
+
+// FunctionRegistry хранит функции, которые можно вызывать в выражениях.
+type FunctionRegistry struct {
+	mutex     sync.RWMutex
+	functions map[string]Function
+}
+
+func NewFunctionRegistry() *FunctionRegistry {
+	return &FunctionRegistry{functions: make(map[string]Function)}
+}
+
+// Register добавляет функцию или заменяет функцию с тем же именем.
+func (r *FunctionRegistry) Register(function Function) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.functions[function.Name] = function
+}
+
+// Lookup возвращает функцию по имени.
+func (r *FunctionRegistry) Lookup(name string) (Function, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	function, found := r.functions[name]
+	return function, found
+}
+
+// Names возвращает имена всех функций по алфавиту.
+func (r *FunctionRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.functions))
+	for name := range r.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Functions - функции, доступные в выражениях.
+var Functions = builtinFunctions()
+
+func builtinFunctions() *FunctionRegistry {
+	r := NewFunctionRegistry()
+	r.Register(Function{Name: "sqrt", MinArgs: 1, MaxArgs: 1, Check: nonNegative})
+	r.Register(Function{Name: "sin", MinArgs: 1, MaxArgs: 1})
+	r.Register(Function{Name: "cos", MinArgs: 1, MaxArgs: 1})
+	// log(x) - натуральный логарифм, log(x, b) - логарифм по основанию b.
+	r.Register(Function{Name: "log", MinArgs: 1, MaxArgs: 2, Check: logArgs})
+	r.Register(Function{Name: "abs", MinArgs: 1, MaxArgs: 1})
+	r.Register(Function{Name: "min", MinArgs: 1, MaxArgs: -1})
+	r.Register(Function{Name: "max", MinArgs: 1, MaxArgs: -1})
+	r.Register(Function{Name: "round", MinArgs: 1, MaxArgs: 1})
+	return r
+}
+
+func nonNegative(args []float64) error {
+	if args[0] < 0 {
+		return ErrInvalidArgument
+	}
+	return nil
+}
+
+func logArgs(args []float64) error {
+	if args[0] <= 0 {
+		return ErrInvalidArgument
+	}
+	if len(args) == 2 && (args[1] <= 0 || args[1] == 1) {
+		return ErrInvalidArgument
+	}
+	return nil
+}
+
+// checkCall проверяет, что функция name существует и принимает argCount аргументов.
+func checkCall(name string, argCount int) (Function, error) {
+	function, found := Functions.Lookup(name)
+	if name == OperationIf {
+		function, found = conditional, true
+	}
+	if !found {
+		return Function{}, fmt.Errorf("%w: %s", ErrUnknownFunction, name)
+	}
+	if argCount < function.MinArgs || (function.MaxArgs >= 0 && argCount > function.MaxArgs) {
+		return Function{}, fmt.Errorf("%w: %s получает %d", ErrArgumentCount, name, argCount)
+	}
+	return function, nil
+}
@@ -0,0 +1,654 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+// node - операция выражения; аргумент slot - число из args или результат deps[slot].
+type node struct {
+	index     int
+	operation string
+	function  *Function
+	args      []Number
+	deps      []*node
+	// Операций в uses несколько, если одинаковые подвыражения вычисляются один раз.
+	uses    []use
+	pending int
+	boolean bool
+}
+
+type use struct {
+	parent *node
+	slot   int
+}
+
+// Дочерние операции в nodes идут раньше родительских. Без операций root равен nil.
+type graph struct {
+	root      *node
+	value     Number
+	nodes     []*node
+	precision Precision
+	numbers   arithmetic
+	shared    bool
+}
+
+type graphBuilder struct {
+	nodes   []*node
+	scope   Scope
+	numbers arithmetic
+	locals  map[string]local
+	params  map[string]*argument
+}
+
+func buildGraph(expr Expr, scope Scope) (graph, error) {
+	if err := checkRecursion(scope.Functions); err != nil {
+		return graph{}, err
+	}
+	b := &graphBuilder{scope: scope, numbers: newArithmetic(scope.Precision)}
+	root, value, err := b.build(expr)
+	if err != nil {
+		return graph{}, err
+	}
+	g := graph{root: root, value: value, nodes: b.nodes, precision: scope.Precision, numbers: b.numbers}
+	if scope.Optimize {
+		g = g.optimize(scope.FoldLimit)
+	}
+	g.shared = scope.Cache
+	return g, nil
+}
+
+// build возвращает значение expr, если оно известно без вычислений.
+func (b *graphBuilder) build(expr Expr) (*node, Number, error) {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		if isImaginary(e.Text) && b.scope.Precision != PrecisionComplex {
+			return nil, nil, fmt.Errorf("%w: мнимое число %s", ErrUnsupported, e.Text)
+		}
+		value, err := b.numbers.parse(e.Text)
+		return nil, value, err
+	case *IdentExpr:
+		if value, found := BooleanConstants[e.Name]; found {
+			return nil, booleanNumber(value), nil
+		}
+		if arg, found := b.params[e.Name]; found {
+			return b.argument(arg)
+		}
+		if local, found := b.locals[e.Name]; found {
+			return local.node, local.value, nil
+		}
+		if digits, found := Constants[e.Name]; found {
+			value, err := b.numbers.parse(digits)
+			return nil, value, err
+		}
+		if value, found := b.scope.Variables[e.Name]; found {
+			number, err := b.numbers.fromFloat(value)
+			return nil, number, err
+		}
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownVariable, e.Name)
+	case *BinaryExpr:
+		switch e.Op {
+		case "&&":
+			return b.conditional(e.Op, e.Left, e.Right, &IdentExpr{Name: "false"})
+		case "||":
+			return b.conditional(e.Op, e.Left, &IdentExpr{Name: "true"}, e.Right)
+		}
+		if !b.numbers.supports(e.Op) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnsupported, e.Op)
+		}
+		n, err := b.add(&node{operation: e.Op, boolean: comparisonOperations[e.Op]}, e.Left, e.Right)
+		return n, nil, err
+	case *UnaryExpr:
+		if e.Op == OperationNot || e.Op == OperationLogicalNot {
+			if !b.numbers.supports(e.Op) {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnsupported, e.Op)
+			}
+			n, err := b.add(&node{operation: e.Op, boolean: e.Op == OperationLogicalNot}, e.Operand)
+			return n, nil, err
+		}
+		child, value, err := b.build(e.Operand)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isBoolean(child, value) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrTypeMismatch, e.Op)
+		}
+		if child == nil {
+			value, err = b.numbers.neg(value)
+			return nil, value, err
+		}
+		// Отрицание выполняет агент: операция "neg" меняет знак Arg1.
+		n := &node{operation: OperationNeg, args: make([]Number, 1), deps: make([]*node, 1)}
+		b.link(n, 0, child)
+		b.append(n)
+		return n, nil, nil
+	case *CallExpr:
+		if definition, found := b.scope.Functions[e.Name]; found {
+			return b.call(e, definition)
+		}
+		function, err := checkCall(e.Name, len(e.Args))
+		if err != nil {
+			return nil, nil, err
+		}
+		if e.Name == OperationIf {
+			return b.conditional(e.Name, e.Args...)
+		}
+		if !b.numbers.supports(e.Name) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnsupported, e.Name)
+		}
+		n, err := b.add(&node{operation: e.Name, function: &function}, e.Args...)
+		return n, nil, err
+	case *ScriptExpr:
+		return b.script(e)
+	}
+	return nil, nil, ErrInvalidExpression
+}
+
+func (b *graphBuilder) add(n *node, operands ...Expr) (*node, error) {
+	n.args = make([]Number, len(operands))
+	n.deps = make([]*node, len(operands))
+	for slot, operand := range operands {
+		child, value, err := b.build(operand)
+		if err != nil {
+			return nil, err
+		}
+		if isBoolean(child, value) != (n.operation == OperationLogicalNot) {
+			return nil, fmt.Errorf("%w: %s", ErrTypeMismatch, n.operation)
+		}
+		if child == nil {
+			n.args[slot] = value
+			continue
+		}
+		b.link(n, slot, child)
+	}
+	b.append(n)
+	return n, nil
+}
+
+// Если условие неизвестно, в граф попадают обе ветви, а задачи получает только выбранная.
+func (b *graphBuilder) conditional(op string, operands ...Expr) (*node, Number, error) {
+	condition, value, err := b.build(operands[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isBoolean(condition, value) {
+		return nil, nil, fmt.Errorf("%w: %s ожидает логическое условие", ErrTypeMismatch, op)
+	}
+	if condition == nil {
+		if value.Sign() != 0 {
+			return b.build(operands[1])
+		}
+		return b.build(operands[2])
+	}
+
+	n := &node{operation: OperationIf, args: make([]Number, 3), deps: make([]*node, 3)}
+	b.link(n, 0, condition)
+	for slot := 1; slot <= 2; slot++ {
+		child, value, err := b.build(operands[slot])
+		if err != nil {
+			return nil, nil, err
+		}
+		if slot == 1 {
+			n.boolean = isBoolean(child, value)
+		} else if isBoolean(child, value) != n.boolean {
+			return nil, nil, fmt.Errorf("%w: ветви %s имеют разные типы", ErrTypeMismatch, op)
+		}
+		if child == nil {
+			n.args[slot] = value
+			continue
+		}
+		b.link(n, slot, child)
+	}
+	b.append(n)
+	return n, nil, nil
+}
+
+func (b *graphBuilder) link(n *node, slot int, child *node) {
+	n.deps[slot] = child
+	n.pending++
+	child.uses = append(child.uses, use{n, slot})
+}
+
+func (b *graphBuilder) append(n *node) {
+	n.index = len(b.nodes)
+	b.nodes = append(b.nodes, n)
+}
+
+// runGraph отправляет задачи, как только известны их аргументы, поэтому независимые
+// oперации вычисляются одновременно.
+func runGraph(ctx context.Context, id string, g graph, done map[int]string, taskChan chan contract.TaskData, results chan contract.TaskResult) (Number, error) {
+	if g.root == nil {
+		return g.value, nil
+	}
+
+	// active - операции, нужные для результата; ready - еще не отправленные из них.
+	resolved := make(map[*node]Number)
+	active := make(map[*node]bool)
+	var ready []*node
+
+	var resolve func(n *node, result Number)
+	var activate func(n *node)
+	// Результат сценария - значение его последней инструкции.
+	schedule := func(n *node) {
+		if n.operation == OperationScript {
+			resolve(n, n.args[len(n.args)-1])
+			return
+		}
+		ready = append(ready, n)
+	}
+	choose := func(n *node) {
+		if _, found := resolved[n]; found || !active[n] || n.args[0] == nil {
+			return
+		}
+		slot := n.branch()
+		dep := n.deps[slot]
+		if dep == nil {
+			resolve(n, n.args[slot])
+		} else if result, found := resolved[dep]; found {
+			resolve(n, result)
+		} else {
+			activate(dep)
+		}
+	}
+	activate = func(n *node) {
+		if _, found := resolved[n]; found || active[n] {
+			return
+		}
+		active[n] = true
+		if n.operation == OperationIf {
+			if n.deps[0] != nil {
+				activate(n.deps[0])
+			}
+			choose(n)
+			return
+		}
+		for _, dep := range n.deps {
+			if dep != nil {
+				activate(dep)
+			}
+		}
+		if n.pending == 0 {
+			schedule(n)
+		}
+	}
+	resolve = func(n *node, result Number) {
+		resolved[n] = result
+		for _, u := range n.uses {
+			u.parent.args[u.slot] = result
+			if u.parent.operation == OperationIf {
+				choose(u.parent)
+				continue
+			}
+			u.parent.pending--
+			if u.parent.pending == 0 && active[u.parent] {
+				schedule(u.parent)
+			}
+		}
+	}
+
+	for _, n := range g.nodes {
+		text, found := done[n.index]
+		if !found {
+			continue
+		}
+		result, err := g.parse(n, text)
+		if err != nil {
+			return nil, err
+		}
+		resolve(n, result)
+	}
+	activate(g.root)
+	if result, found := resolved[g.root]; found {
+		Tasks.Forget(id)
+		return result, nil
+	}
+
+	inflight := make(map[int]*node)
+	// leaders - операции, результата которых ждут другие выражения.
+	leaders := make(map[*node]string)
+	shared := make(chan sharedResult)
+	stop := make(chan struct{})
+	waiting := 0
+	var calcErr error
+	defer Tasks.Forget(id)
+	defer close(stop)
+	defer func() {
+		for _, key := range leaders {
+			Cache.release(key, nil, "", false)
+		}
+	}()
+
+	share := func(r sharedResult) {
+		go func() {
+			select {
+			case shared <- r:
+			case <-stop:
+			}
+		}()
+	}
+
+	dispatch := func(n *node) error {
+		if err := g.check(n); err != nil {
+			return err
+		}
+		if g.shared && cacheable(n) {
+			key := g.cacheKey(n)
+			entry, status := Cache.acquire(key, func(entry cacheEntry, ok bool) {
+				share(sharedResult{n, entry, ok})
+			})
+			switch status {
+			case cacheHit:
+				waiting++
+				share(sharedResult{n, entry, true})
+				return nil
+			case cacheJoined:
+				log.Printf("runGraph: выражение %s ждет результата такой же операции: %s", id, key)
+				waiting++
+				return nil
+			}
+			leaders[n] = key
+		}
+		parent, slot := -1, 0
+		if len(n.uses) > 0 {
+			parent, slot = n.uses[0].parent.index, n.uses[0].slot
+		}
+		task := Tasks.Add(contract.Task{
+			ExpressionID: id,
+			Node:         n.index,
+			Parent:       parent,
+			Slot:         slot,
+			Data:         n.taskData(g.numbers),
+		})
+		inflight[task.ID] = n
+		log.Printf("runGraph: отправка задачи %d для выражения %s: %s %v", task.ID, id, n.operation, n.args)
+		select {
+		case taskChan <- task.Data:
+			return nil
+		case <-ctx.Done():
+			return contextError(ctx)
+		}
+	}
+
+	flush := func() error {
+		for len(ready) > 0 {
+			n := ready[0]
+			ready = ready[1:]
+			if err := dispatch(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	calcErr = flush()
+
+	// Отправленные задачи дожидаемся, чтобы агенты не блокировались на отправке результата.
+	for len(inflight) > 0 || waiting > 0 {
+		var n *node
+		var result Number
+		select {
+		case taskResult := <-results:
+			var found bool
+			n, found = inflight[taskResult.ID]
+			if !found {
+				continue
+			}
+			delete(inflight, taskResult.ID)
+			var err error
+			result, err = g.receive(id, n, taskResult)
+			if key, found := leaders[n]; found {
+				delete(leaders, n)
+				// Ответы нейросети не кэшируются: их нужно перепроверять.
+				Cache.release(key, result, taskResult.Agent, err == nil && !taskResult.AI)
+			}
+			if err != nil {
+				if calcErr == nil {
+					calcErr = err
+				}
+				continue
+			}
+		case r := <-shared:
+			waiting--
+			n = r.node
+			if !r.ok {
+				if calcErr == nil {
+					calcErr = dispatch(n)
+				}
+				continue
+			}
+			result = r.entry.result
+			log.Printf("runGraph: результат операции %s для выражения %s взят из кэша: %s", r.entry.key, id, result)
+			saveStep(id, n, contract.TaskResult{Agent: r.entry.agent}, result, nil, true)
+		case <-ctx.Done():
+			log.Printf("runGraph: вычисление выражения %s прервано: %v", id, ctx.Err())
+			return nil, contextError(ctx)
+		}
+
+		resolve(n, result)
+		if result, found := resolved[g.root]; found {
+			return result, calcErr
+		}
+		if calcErr == nil {
+			calcErr = flush()
+		}
+	}
+
+	return nil, calcErr
+}
+
+type sharedResult struct {
+	node  *node
+	entry cacheEntry
+	ok    bool
+}
+
+func (g graph) receive(id string, n *node, taskResult contract.TaskResult) (Number, error) {
+	if taskResult.Err != nil {
+		log.Printf("runGraph: задача %d для выражения %s не выполнена: %v", taskResult.ID, id, taskResult.Err)
+		saveStep(id, n, taskResult, nil, taskResult.Err, false)
+		return nil, taskResult.Err
+	}
+	result, err := g.result(n, taskResult)
+	saveStep(id, n, taskResult, result, err, false)
+	if err != nil {
+		log.Printf("runGraph: неправильный результат задачи %d для выражения %s: %+v", taskResult.ID, id, taskResult)
+		return nil, err
+	}
+	log.Printf("runGraph: получен результат задачи %d для выражения %s: %s", taskResult.ID, id, result)
+	if err := Journal.SaveResult(taskResult.ID, result.Float64(), result.String()); err != nil {
+		log.Printf("runGraph: не удалось сохранить результат задачи %d: %v", taskResult.ID, err)
+	}
+	return result, nil
+}
+
+func (g graph) result(n *node, taskResult contract.TaskResult) (Number, error) {
+	if n.boolean {
+		return booleanResult(taskResult)
+	}
+	return g.numbers.result(taskResult)
+}
+
+func (g graph) parse(n *node, text string) (Number, error) {
+	if n.boolean {
+		return parseBoolean(text)
+	}
+	return g.numbers.parse(text)
+}
+
+func saveStep(id string, n *node, taskResult contract.TaskResult, result Number, err error, cached bool) {
+	step := contract.TraceStep{
+		TaskID:    taskResult.ID,
+		Operation: n.operation,
+		Operands:  make([]string, len(n.args)),
+		Agent:     taskResult.Agent,
+		Cached:    cached,
+	}
+	for i, arg := range n.args {
+		step.Operands[i] = arg.String()
+	}
+	if result != nil {
+		step.Result = result.String()
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if task, found := Tasks.Get(taskResult.ID); found && !cached {
+		step.Attempts = task.Attempts
+		if !task.LeasedAt.IsZero() {
+			step.WaitMs = task.LeasedAt.Sub(task.QueuedAt).Milliseconds()
+			if !task.FinishedAt.IsZero() {
+				step.ComputeMs = task.FinishedAt.Sub(task.LeasedAt).Milliseconds()
+			}
+		}
+	}
+	if err := Journal.SaveStep(id, step); err != nil {
+		log.Printf("runGraph: не удалось сохранить шаг трассировки задачи %d: %v", taskResult.ID, err)
+	}
+}
+
+func (g graph) check(n *node) error {
+	if isDivision(n.operation) && n.args[1].Sign() == 0 {
+		return ErrNullDivision
+	}
+	// Проверки функций рассчитаны на float64.
+	_, exact := g.numbers.(ratArithmetic)
+	if n.function != nil && n.function.Check != nil && g.precision != PrecisionComplex && !exact {
+		if err := n.function.Check(floats(n.args)); err != nil {
+			return err
+		}
+	}
+	return g.numbers.check(n.operation, n.args)
+}
+
+// criticalPath возвращает время самой долгой цепочки операций в миллисекундах.
+func (g graph) criticalPath() int {
+	if g.root == nil {
+		return 0
+	}
+	finish := make([]int, len(g.nodes))
+	for _, n := range g.nodes {
+		start := 0
+		for _, dep := range n.deps {
+			if dep != nil {
+				start = max(start, finish[dep.index])
+			}
+		}
+		finish[n.index] = start + operationTime(n.operation)
+	}
+	return finish[g.root.index]
+}
+
+// required возвращает все операции, кроме операций ветвей.
+func (g graph) required() map[*node]bool {
+	required := make(map[*node]bool)
+	var visit func(n *node)
+	visit = func(n *node) {
+		if required[n] {
+			return
+		}
+		required[n] = true
+		deps := n.deps
+		if n.operation == OperationIf {
+			deps = deps[:1]
+		}
+		for _, dep := range deps {
+			if dep != nil {
+				visit(dep)
+			}
+		}
+	}
+	if g.root != nil {
+		visit(g.root)
+	}
+	return required
+}
+
+// Операции обеих ветвей условия учитываются, хотя задачи получит только одна.
+func (g graph) tasks() int {
+	count := 0
+	for _, n := range g.nodes {
+		if !n.local() {
+			count++
+		}
+	}
+	return count
+}
+
+// local сообщает, что операцию n вычисляет сам оркестратор.
+func (n *node) local() bool {
+	return n.operation == OperationIf || n.operation == OperationScript
+}
+
+func (n *node) taskData(numbers arithmetic) contract.TaskData {
+	data := contract.TaskData{
+		Operation:     n.operation,
+		OperationTime: operationTime(n.operation),
+	}
+	if n.operation == OperationLogicalNot {
+		// Логическое значение передается в любом режиме числом: 1 или 0.
+		data.Arg1 = n.args[0].Float64()
+		return data
+	}
+	numbers.encode(&data, n.args)
+	if n.function != nil {
+		data.Args = floats(n.args)
+		return data
+	}
+	data.Arg1 = n.args[0].Float64()
+	if len(n.args) > 1 {
+		data.Arg2 = n.args[1].Float64()
+	}
+	return data
+}
+
+func floats(numbers []Number) []float64 {
+	values := make([]float64, len(numbers))
+	for i, number := range numbers {
+		values[i] = number.Float64()
+	}
+	return values
+}
+
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrDeadlineExceeded
+	}
+	return ErrCancelled
+}
+
+// OperationNeg - операция смены знака, которую выполняют агенты.
+const OperationNeg = "neg"
+
+func operationTime(operation string) int {
+	switch operation {
+	case "+":
+		return contract.AppConfig.TIME_ADDITION_MS
+	case "-", OperationNeg:
+		return contract.AppConfig.TIME_SUBTRACTION_MS
+	case "*":
+		return contract.AppConfig.TIME_MULTIPLICATIONS_MS
+	case "/":
+		return contract.AppConfig.TIME_DIVISIONS_MS
+	case "//":
+		return contract.AppConfig.TIME_INTEGER_DIVISIONS_MS
+	case "%":
+		return contract.AppConfig.TIME_MODULO_MS
+	case "^":
+		return contract.AppConfig.TIME_EXPONENTIATIONS_MS
+	case "&", "|", "<<", ">>", OperationNot:
+		return contract.AppConfig.TIME_BITWISE_MS
+	case "==", "!=", "<", "<=", ">", ">=", OperationLogicalNot:
+		return contract.AppConfig.TIME_COMPARISON_MS
+	case OperationIf, OperationScript:
+		return 0
+	}
+	return contract.AppConfig.TIME_FUNCTIONS_MS[operation]
+}
+
+func isDivision(operation string) bool {
+	return operation == "/" || operation == "//" || operation == "%"
+}
@@ -0,0 +1,173 @@
+package calc
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Pos - смещение в символах с нуля, строка и столбец с единицы.
+type Pos struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenIdent
+	TokenComma
+	TokenAssign
+	TokenSemicolon
+)
+
+// Token - лексема выражения.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Pos
+}
+
+var operatorAliases = map[rune]string{
+	'×': "*",
+	'·': "*",
+}
+
+var twoCharOperators = map[string]bool{
+	"//": true,
+	"<<": true,
+	">>": true,
+	"==": true,
+	"!=": true,
+	"<=": true,
+	">=": true,
+	"&&": true,
+	"||": true,
+}
+
+// Lexer разбивает текст выражения на лексемы. Пробельные символы пропускаются.
+type Lexer struct {
+	input []rune
+	index int
+	pos   Pos
+}
+
+func NewLexer(expression string) *Lexer {
+	return &Lexer{input: []rune(expression), pos: Pos{Line: 1, Column: 1}}
+}
+
+// Tokenize возвращает все лексемы выражения, последней идет TokenEOF.
+func Tokenize(expression string) ([]Token, error) {
+	lexer := NewLexer(expression)
+	var tokens []Token
+	for {
+		token, err := lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// Next возвращает следующую лексему.
+func (l *Lexer) Next() (Token, error) {
+	for l.index < len(l.input) && unicode.IsSpace(l.input[l.index]) {
+		l.advance()
+	}
+	if l.index >= len(l.input) {
+		return Token{Kind: TokenEOF, Pos: l.pos}, nil
+	}
+
+	start := l.pos
+	r := l.input[l.index]
+	switch {
+	case r == '0' && l.index+1 < len(l.input) && isBasePrefix(l.input[l.index+1]):
+		begin := l.index
+		l.advance()
+		l.advance()
+		for l.index < len(l.input) && (isLetter(l.input[l.index]) || isDigit(l.input[l.index])) {
+			l.advance()
+		}
+		return Token{Kind: TokenNumber, Text: string(l.input[begin:l.index]), Pos: start}, nil
+	case isDigit(r) || r == '.':
+		begin := l.index
+		for l.index < len(l.input) && (isDigit(l.input[l.index]) || l.input[l.index] == '.') {
+			l.advance()
+		}
+		// Суффикс i входит в число, только если за ним нет буквы или цифры.
+		if l.index < len(l.input) && l.input[l.index] == ImaginaryUnit &&
+			(l.index+1 == len(l.input) || !(isLetter(l.input[l.index+1]) || isDigit(l.input[l.index+1]))) {
+			l.advance()
+		}
+		return Token{Kind: TokenNumber, Text: string(l.input[begin:l.index]), Pos: start}, nil
+	case r == '(':
+		l.advance()
+		return Token{Kind: TokenLParen, Text: "(", Pos: start}, nil
+	case r == ')':
+		l.advance()
+		return Token{Kind: TokenRParen, Text: ")", Pos: start}, nil
+	case r == ',':
+		l.advance()
+		return Token{Kind: TokenComma, Text: ",", Pos: start}, nil
+	case r == ';':
+		l.advance()
+		return Token{Kind: TokenSemicolon, Text: ";", Pos: start}, nil
+	case l.index+1 < len(l.input) && twoCharOperators[string(l.input[l.index:l.index+2])]:
+		l.advance()
+		l.advance()
+		return Token{Kind: TokenOperator, Text: string(l.input[l.index-2 : l.index]), Pos: start}, nil
+	case r == '=':
+		l.advance()
+		return Token{Kind: TokenAssign, Text: "=", Pos: start}, nil
+	case isLetter(r):
+		begin := l.index
+		for l.index < len(l.input) && (isLetter(l.input[l.index]) || isDigit(l.input[l.index])) {
+			l.advance()
+		}
+		return Token{Kind: TokenIdent, Text: string(l.input[begin:l.index]), Pos: start}, nil
+	case strings.ContainsRune("+-*/^%&|~<>!", r):
+		l.advance()
+		return Token{Kind: TokenOperator, Text: string(r), Pos: start}, nil
+	}
+	if alias, found := operatorAliases[r]; found {
+		l.advance()
+		return Token{Kind: TokenOperator, Text: alias, Pos: start}, nil
+	}
+	return Token{}, &ParseError{Err: ErrIllegalSign, Pos: start, Token: string(r), Expression: string(l.input)}
+}
+
+func (l *Lexer) advance() {
+	if l.input[l.index] == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	l.index++
+	l.pos.Offset++
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// isBasePrefix сообщает, что после нуля идет префикс системы счисления.
+func isBasePrefix(r rune) bool {
+	switch r {
+	case 'x', 'X', 'b', 'B', 'o', 'O':
+		return true
+	}
+	return false
+}
+
+func isLetter(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
@@ -0,0 +1,79 @@
+package calc
+
+import "github.com/veronicashkarova/server-for-calc/pkg/contract"
+
+// OperationIf вычисляет сам оркестратор, а задачи получает только выбранная ветвь.
+const OperationIf = "if"
+
+// OperationLogicalNot - логическое отрицание, которое выполняют агенты.
+const OperationLogicalNot = "!"
+
+var comparisonOperations = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+var BooleanConstants = map[string]bool{
+	"true":  true,
+	"false": false,
+}
+
+// conditional - функция if для проверки числа аргументов (см. checkCall).
+var conditional = Function{Name: OperationIf, MinArgs: 3, MaxArgs: 3}
+
+type booleanNumber bool
+
+func (b booleanNumber) String() string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func (b booleanNumber) Float64() float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (b booleanNumber) Sign() int { return int(b.Float64()) }
+
+// parseBoolean разбирает логическое значение в записи String.
+func parseBoolean(text string) (Number, error) {
+	value, found := BooleanConstants[text]
+	if !found {
+		return nil, ErrInvalidResult
+	}
+	return booleanNumber(value), nil
+}
+
+func booleanResult(taskResult contract.TaskResult) (Number, error) {
+	switch taskResult.Result {
+	case 1:
+		return booleanNumber(true), nil
+	case 0:
+		return booleanNumber(false), nil
+	}
+	return nil, ErrInvalidResult
+}
+
+// isBoolean сообщает, что операция n или значение value логические.
+func isBoolean(n *node, value Number) bool {
+	if n != nil {
+		return n.boolean
+	}
+	_, ok := value.(booleanNumber)
+	return ok
+}
+
+func (n *node) branch() int {
+	if n.args[0].Sign() != 0 {
+		return 1
+	}
+	return 2
+}
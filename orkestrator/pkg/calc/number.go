@@ -0,0 +1,652 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+// Precision - режим вычисления выражения.
+type Precision string
+
+const (
+	// PrecisionFloat - режим по умолчанию.
+	PrecisionFloat Precision = "float"
+	// PrecisionDecimal - точные десятичные дроби, агенты считают в math/big.
+	PrecisionDecimal Precision = "decimal"
+	// PrecisionRational - обыкновенные дроби вида "1/3".
+	PrecisionRational Precision = "rational"
+	// PrecisionInteger - 64-битные целые числа со знаком и побитовые операции.
+	PrecisionInteger Precision = "integer"
+	// PrecisionComplex - комплексные числа, например 4i.
+	PrecisionComplex Precision = "complex"
+)
+
+// ParsePrecision считает пустую строку режимом по умолчанию.
+func ParsePrecision(precision string) (Precision, error) {
+	switch Precision(precision) {
+	case "", PrecisionFloat:
+		return PrecisionFloat, nil
+	case PrecisionDecimal, PrecisionRational, PrecisionInteger, PrecisionComplex:
+		return Precision(precision), nil
+	}
+	return "", ErrInvalidPrecision
+}
+
+// Approximate возвращает десятичное приближение результата в режиме rational.
+func (p Precision) Approximate(result string) string {
+	if p != PrecisionRational {
+		return ""
+	}
+	rat, ok := new(big.Rat).SetString(result)
+	if !ok {
+		return ""
+	}
+	return formatDecimal(rat)
+}
+
+// Hex возвращает запись результата в режиме integer, например "0x1a" или
+// "0xffffffffffffffff" для -1.
+func (p Precision) Hex(result string) string {
+	if p != PrecisionInteger {
+		return ""
+	}
+	value, err := strconv.ParseInt(result, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%#x", uint64(value))
+}
+
+func (p Precision) Complex(result string) *contract.Complex {
+	if p != PrecisionComplex {
+		return nil
+	}
+	value, err := strconv.ParseComplex(result, 128)
+	if err != nil {
+		return nil
+	}
+	return &contract.Complex{Real: real(value), Imag: imag(value)}
+}
+
+// Number - аргумент или результат операции в одном из режимов вычисления.
+type Number interface {
+	// String возвращает точную запись, в которой число передается агентам.
+	String() string
+	Float64() float64
+	Sign() int
+}
+
+// FormatResult возвращает результат так, как его получает пользователь.
+func FormatResult(n Number) string {
+	if f, ok := n.(floatNumber); ok {
+		return strconv.FormatFloat(float64(f), 'f', 3, 64)
+	}
+	return n.String()
+}
+
+// arithmetic - числа режима вычисления. Операции над ними выполняют агенты, кроме fold.
+type arithmetic interface {
+	parse(text string) (Number, error)
+	fromFloat(value float64) (Number, error)
+	neg(n Number) (Number, error)
+	supports(operation string) bool
+	check(operation string, args []Number) error
+	encode(data *contract.TaskData, args []Number)
+	result(taskResult contract.TaskResult) (Number, error)
+	// ok равно false, если операцию должен выполнить агент.
+	fold(operation string, args []Number) (result Number, ok bool)
+}
+
+func newArithmetic(precision Precision) arithmetic {
+	switch precision {
+	case PrecisionDecimal, PrecisionRational:
+		return ratArithmetic{precision}
+	case PrecisionInteger:
+		return integerArithmetic{}
+	case PrecisionComplex:
+		return complexArithmetic{}
+	}
+	return floatArithmetic{}
+}
+
+const OperationNot = "~"
+
+var integerOperations = map[string]bool{
+	"&":          true,
+	"|":          true,
+	"<<":         true,
+	">>":         true,
+	OperationNot: true,
+}
+
+var integerFunctions = map[string]bool{
+	"abs":   true,
+	"min":   true,
+	"max":   true,
+	"round": true,
+}
+
+// complexUnsupported - операции, которым нужно сравнение или округление чисел.
+var complexUnsupported = map[string]bool{
+	"<":     true,
+	"<=":    true,
+	">":     true,
+	">=":    true,
+	"//":    true,
+	"%":     true,
+	"min":   true,
+	"max":   true,
+	"round": true,
+}
+
+const ImaginaryUnit = 'i'
+
+func isImaginary(text string) bool {
+	return strings.HasSuffix(text, string(ImaginaryUnit)) && !hasBasePrefix(text)
+}
+
+func parseNumber(text string) (float64, error) {
+	if hasBasePrefix(text) {
+		value, err := parseInteger(text)
+		return float64(value), err
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, ErrInvalidExpression
+	}
+	return value, nil
+}
+
+// Без префикса число всегда десятичное: 010 - это 10, а не 8.
+func parseInteger(text string) (int64, error) {
+	base := 10
+	if hasBasePrefix(text) {
+		base = 0
+	}
+	value, err := strconv.ParseInt(text, base, 64)
+	switch {
+	case errors.Is(err, strconv.ErrRange):
+		return 0, ErrIntegerOverflow
+	case err != nil:
+		if _, floatErr := strconv.ParseFloat(text, 64); floatErr == nil && base == 10 {
+			return 0, ErrNotInteger
+		}
+		return 0, ErrInvalidExpression
+	}
+	return value, nil
+}
+
+func hasBasePrefix(text string) bool {
+	digits := strings.TrimPrefix(text, "-")
+	return len(digits) > 1 && digits[0] == '0' && isBasePrefix(rune(digits[1]))
+}
+
+type floatNumber float64
+
+func (f floatNumber) String() string   { return strconv.FormatFloat(float64(f), 'g', -1, 64) }
+func (f floatNumber) Float64() float64 { return float64(f) }
+
+func (f floatNumber) Sign() int {
+	switch {
+	case f > 0:
+		return 1
+	case f < 0:
+		return -1
+	}
+	return 0
+}
+
+type floatArithmetic struct{}
+
+func (floatArithmetic) parse(text string) (Number, error) {
+	value, err := parseNumber(text)
+	if err != nil {
+		return nil, err
+	}
+	return floatNumber(value), nil
+}
+
+func (floatArithmetic) fromFloat(value float64) (Number, error) { return floatNumber(value), nil }
+func (floatArithmetic) neg(n Number) (Number, error)            { return -n.(floatNumber), nil }
+func (floatArithmetic) supports(operation string) bool          { return !integerOperations[operation] }
+func (floatArithmetic) check(string, []Number) error            { return nil }
+func (floatArithmetic) encode(*contract.TaskData, []Number)     {}
+
+func (floatArithmetic) result(taskResult contract.TaskResult) (Number, error) {
+	return floatNumber(taskResult.Result), nil
+}
+
+func (floatArithmetic) fold(operation string, args []Number) (Number, bool) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		values[i] = float64(arg.(floatNumber))
+	}
+	var result float64
+	switch {
+	case operation == OperationNeg:
+		result = -values[0]
+	case len(values) != 2:
+		return nil, false
+	case operation == "+":
+		result = values[0] + values[1]
+	case operation == "-":
+		result = values[0] - values[1]
+	case operation == "*":
+		result = values[0] * values[1]
+	case operation == "/" && values[1] != 0:
+		result = values[0] / values[1]
+	default:
+		return nil, false
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nil, false
+	}
+	return floatNumber(result), true
+}
+
+// ratNumber хранит big.Rat, чтобы 0.1 не превращалась в двоичную дробь, а 1/3 - в 0.333.
+type ratNumber struct {
+	rat       *big.Rat
+	precision Precision
+}
+
+func (n ratNumber) String() string {
+	if n.precision == PrecisionRational {
+		return n.rat.RatString()
+	}
+	return formatDecimal(n.rat)
+}
+
+func (n ratNumber) Float64() float64 {
+	value, _ := n.rat.Float64()
+	return value
+}
+
+func (n ratNumber) Sign() int { return n.rat.Sign() }
+
+type ratArithmetic struct {
+	precision Precision
+}
+
+func (a ratArithmetic) parse(text string) (Number, error) {
+	if hasBasePrefix(text) {
+		value, err := parseInteger(text)
+		if err != nil {
+			return nil, err
+		}
+		return ratNumber{big.NewRat(value, 1), a.precision}, nil
+	}
+	rat, ok := new(big.Rat).SetString(text)
+	if !ok || (a.precision != PrecisionRational && strings.Contains(text, "/")) {
+		return nil, ErrInvalidExpression
+	}
+	return ratNumber{rat, a.precision}, nil
+}
+
+func (a ratArithmetic) fromFloat(value float64) (Number, error) {
+	// Кратчайшая запись: переменная 0.1 становится ровно 0.1.
+	return a.parse(strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (a ratArithmetic) neg(n Number) (Number, error) {
+	return ratNumber{new(big.Rat).Neg(n.(ratNumber).rat), a.precision}, nil
+}
+
+func (ratArithmetic) supports(operation string) bool { return !integerOperations[operation] }
+
+// maxExactExponent совпадает с ограничением агента.
+const maxExactExponent = 1000
+
+// Float-проверки функций превратили бы 1e-400 в ноль.
+func (ratArithmetic) check(operation string, args []Number) error {
+	rats := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		rats[i] = arg.(ratNumber).rat
+	}
+	switch operation {
+	case "sqrt":
+		if rats[0].Sign() < 0 {
+			return ErrInvalidArgument
+		}
+	case "log":
+		if rats[0].Sign() <= 0 {
+			return ErrInvalidArgument
+		}
+		if len(rats) == 2 && (rats[1].Sign() <= 0 || rats[1].Cmp(big.NewRat(1, 1)) == 0) {
+			return ErrInvalidArgument
+		}
+	case "^":
+		base, exponent := rats[0], rats[1]
+		if base.Sign() == 0 && exponent.Sign() < 0 {
+			return ErrNullDivision
+		}
+		if exponent.IsInt() && exponent.Num().CmpAbs(big.NewInt(maxExactExponent)) <= 0 {
+			return nil
+		}
+		b, _ := base.Float64()
+		e, _ := exponent.Float64()
+		if result := math.Pow(b, e); math.IsNaN(result) || math.IsInf(result, 0) {
+			return ErrInvalidArgument
+		}
+	}
+	return nil
+}
+
+func (a ratArithmetic) encode(data *contract.TaskData, args []Number) {
+	data.Precision = string(a.precision)
+	data.ExactArgs = make([]string, len(args))
+	for i, arg := range args {
+		data.ExactArgs[i] = arg.String()
+	}
+}
+
+func (a ratArithmetic) fold(operation string, args []Number) (Number, bool) {
+	rats := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		rats[i] = arg.(ratNumber).rat
+	}
+	result := new(big.Rat)
+	switch {
+	case operation == OperationNeg:
+		result.Neg(rats[0])
+	case len(rats) != 2:
+		return nil, false
+	case operation == "+":
+		result.Add(rats[0], rats[1])
+	case operation == "-":
+		result.Sub(rats[0], rats[1])
+	case operation == "*":
+		result.Mul(rats[0], rats[1])
+	case operation == "/" && rats[1].Sign() != 0:
+		result.Quo(rats[0], rats[1])
+	default:
+		return nil, false
+	}
+	// Агент округляет бесконечную дробь до DecimalDigits знаков.
+	number, err := a.parse(ratNumber{result, a.precision}.String())
+	return number, err == nil
+}
+
+func (a ratArithmetic) result(taskResult contract.TaskResult) (Number, error) {
+	if taskResult.Exact == "" {
+		return nil, ErrInvalidResult
+	}
+	number, err := a.parse(taskResult.Exact)
+	if err != nil {
+		return nil, ErrInvalidResult
+	}
+	return number, nil
+}
+
+type integerNumber int64
+
+func (i integerNumber) String() string   { return strconv.FormatInt(int64(i), 10) }
+func (i integerNumber) Float64() float64 { return float64(i) }
+
+func (i integerNumber) Sign() int {
+	switch {
+	case i > 0:
+		return 1
+	case i < 0:
+		return -1
+	}
+	return 0
+}
+
+type integerArithmetic struct{}
+
+func (integerArithmetic) parse(text string) (Number, error) {
+	value, err := parseInteger(text)
+	if err != nil {
+		return nil, err
+	}
+	return integerNumber(value), nil
+}
+
+func (integerArithmetic) fromFloat(value float64) (Number, error) {
+	if value != math.Trunc(value) {
+		return nil, ErrNotInteger
+	}
+	if value < math.MinInt64 || value >= math.MaxInt64 {
+		return nil, ErrIntegerOverflow
+	}
+	return integerNumber(value), nil
+}
+
+func (integerArithmetic) neg(n Number) (Number, error) {
+	if n.(integerNumber) == math.MinInt64 {
+		return nil, ErrIntegerOverflow
+	}
+	return -n.(integerNumber), nil
+}
+
+func (integerArithmetic) supports(operation string) bool {
+	_, isFunction := Functions.Lookup(operation)
+	return !isFunction || integerFunctions[operation]
+}
+
+func (integerArithmetic) check(operation string, args []Number) error {
+	switch operation {
+	case "^", "<<", ">>":
+		if args[1].Sign() < 0 {
+			return ErrInvalidArgument
+		}
+	}
+	return nil
+}
+
+func (integerArithmetic) encode(data *contract.TaskData, args []Number) {
+	data.Precision = string(PrecisionInteger)
+	data.IntArgs = make([]int64, len(args))
+	for i, arg := range args {
+		data.IntArgs[i] = int64(arg.(integerNumber))
+	}
+}
+
+func (integerArithmetic) result(taskResult contract.TaskResult) (Number, error) {
+	if taskResult.Overflow {
+		return nil, ErrIntegerOverflow
+	}
+	return integerNumber(taskResult.IntResult), nil
+}
+
+// О переполнении сообщает агент.
+func (integerArithmetic) fold(operation string, args []Number) (Number, bool) {
+	values := make([]*big.Int, len(args))
+	for i, arg := range args {
+		values[i] = big.NewInt(int64(arg.(integerNumber)))
+	}
+	result := new(big.Int)
+	switch {
+	case operation == OperationNeg:
+		result.Neg(values[0])
+	case len(values) != 2:
+		return nil, false
+	case operation == "+":
+		result.Add(values[0], values[1])
+	case operation == "-":
+		result.Sub(values[0], values[1])
+	case operation == "*":
+		result.Mul(values[0], values[1])
+	default:
+		return nil, false
+	}
+	if !result.IsInt64() {
+		return nil, false
+	}
+	return integerNumber(result.Int64()), true
+}
+
+type complexNumber complex128
+
+func (c complexNumber) String() string   { return formatComplex(complex128(c)) }
+func (c complexNumber) Float64() float64 { return real(c) }
+
+// Sign комплексного числа сообщает только, равно ли оно нулю.
+func (c complexNumber) Sign() int {
+	if c == 0 {
+		return 0
+	}
+	return 1
+}
+
+type complexArithmetic struct{}
+
+// Комплексные результаты хранятся в журнале в виде 3+4i.
+func (complexArithmetic) parse(text string) (Number, error) {
+	if hasBasePrefix(text) {
+		value, err := parseInteger(text)
+		if err != nil {
+			return nil, err
+		}
+		return complexNumber(complex(float64(value), 0)), nil
+	}
+	value, err := strconv.ParseComplex(text, 128)
+	if err != nil {
+		return nil, ErrInvalidExpression
+	}
+	return complexNumber(value), nil
+}
+
+func (complexArithmetic) fromFloat(value float64) (Number, error) {
+	return complexNumber(complex(value, 0)), nil
+}
+
+func (complexArithmetic) neg(n Number) (Number, error) { return -n.(complexNumber), nil }
+
+func (complexArithmetic) supports(operation string) bool {
+	return !integerOperations[operation] && !complexUnsupported[operation]
+}
+
+func (complexArithmetic) check(operation string, args []Number) error {
+	switch operation {
+	case "log":
+		for _, arg := range args {
+			if arg.Sign() == 0 {
+				return ErrInvalidArgument
+			}
+		}
+		if len(args) == 2 && args[1].(complexNumber) == 1 {
+			return ErrInvalidArgument
+		}
+	case "^":
+		if args[0].Sign() == 0 && real(args[1].(complexNumber)) < 0 {
+			return ErrNullDivision
+		}
+	}
+	return nil
+}
+
+func (complexArithmetic) encode(data *contract.TaskData, args []Number) {
+	data.Precision = string(PrecisionComplex)
+	data.ComplexArgs = make([]contract.Complex, len(args))
+	for i, arg := range args {
+		value := complex128(arg.(complexNumber))
+		data.ComplexArgs[i] = contract.Complex{Real: real(value), Imag: imag(value)}
+	}
+}
+
+func (complexArithmetic) result(taskResult contract.TaskResult) (Number, error) {
+	if taskResult.Complex == nil {
+		return nil, ErrInvalidResult
+	}
+	value := complex(taskResult.Complex.Real, taskResult.Complex.Imag)
+	if cmplx.IsNaN(value) || cmplx.IsInf(value) {
+		return nil, ErrInvalidResult
+	}
+	return complexNumber(value), nil
+}
+
+func (complexArithmetic) fold(operation string, args []Number) (Number, bool) {
+	values := make([]complex128, len(args))
+	for i, arg := range args {
+		values[i] = complex128(arg.(complexNumber))
+	}
+	var result complex128
+	switch {
+	case operation == OperationNeg:
+		result = -values[0]
+	case len(values) != 2:
+		return nil, false
+	case operation == "+":
+		result = values[0] + values[1]
+	case operation == "-":
+		result = values[0] - values[1]
+	case operation == "*":
+		result = values[0] * values[1]
+	case operation == "/" && values[1] != 0:
+		result = values[0] / values[1]
+	default:
+		return nil, false
+	}
+	if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+		return nil, false
+	}
+	return complexNumber(result), true
+}
+
+// formatComplex записывает 11-2i, 5 или 1i; такую запись разбирает strconv.ParseComplex.
+func formatComplex(value complex128) string {
+	if value == 0 {
+		return "0"
+	}
+	re := strconv.FormatFloat(real(value), 'g', -1, 64)
+	if imag(value) == 0 {
+		return re
+	}
+	im := strconv.FormatFloat(imag(value), 'g', -1, 64) + string(ImaginaryUnit)
+	if real(value) == 0 {
+		return im
+	}
+	if imag(value) > 0 {
+		im = "+" + im
+	}
+	return re + im
+}
+
+// Бесконечные дроби formatDecimal округляет до DecimalDigits знаков.
+func formatDecimal(rat *big.Rat) string {
+	if rat.IsInt() {
+		return rat.Num().String()
+	}
+	text := rat.FloatString(decimalPlaces(rat))
+	text = strings.TrimRight(text, "0")
+	text = strings.TrimSuffix(text, ".")
+	if text == "-0" {
+		return "0"
+	}
+	return text
+}
+
+// Знаменателю 2^a * 5^b нужно max(a, b) знаков после запятой.
+func decimalPlaces(rat *big.Rat) int {
+	denominator := new(big.Int).Set(rat.Denom())
+	places := 0
+	for _, factor := range []int64{2, 5} {
+		count := 0
+		divisor := big.NewInt(factor)
+		remainder := new(big.Int)
+		for {
+			quotient, r := new(big.Int).QuoRem(denominator, divisor, remainder)
+			if r.Sign() != 0 {
+				break
+			}
+			denominator = quotient
+			count++
+		}
+		places = max(places, count)
+	}
+	if denominator.Cmp(big.NewInt(1)) != 0 {
+		return DecimalDigits
+	}
+	return places
+}
+
+const DecimalDigits = 34
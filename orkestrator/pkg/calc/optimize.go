@@ -0,0 +1,176 @@
+package calc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// optimize сворачивает константы, убирает тождества и повторяющиеся операции.
+// Операции, которые не прошли бы check (например, 1/0), не упрощаются.
+func (g graph) optimize(limit int) graph {
+	if g.root == nil {
+		return g
+	}
+	values := make(map[*node]Number)
+	replaced := make(map[*node]*node)
+	// size - число операций подвыражения из одних чисел.
+	size := make(map[*node]int)
+	seen := make(map[string]*node)
+
+	for _, n := range g.nodes {
+		literal := true
+		size[n] = 1
+		for slot, dep := range n.deps {
+			if dep == nil {
+				continue
+			}
+			if value, found := values[dep]; found {
+				n.args[slot] = value
+				n.deps[slot] = nil
+				size[n] += size[dep]
+				continue
+			}
+			literal = false
+			if same, found := replaced[dep]; found {
+				n.deps[slot] = same
+			}
+		}
+
+		// Сравнения вычисляют агенты, а условия и результат сценария - runGraph.
+		if literal && !n.boolean && !n.local() {
+			if g.check(n) != nil {
+				continue
+			}
+			if size[n] <= limit {
+				if value, ok := g.numbers.fold(n.operation, n.args); ok {
+					values[n] = value
+					continue
+				}
+			}
+		}
+		if same, value := g.simplify(n); same != nil {
+			replaced[n] = same
+			continue
+		} else if value != nil {
+			values[n] = value
+			continue
+		}
+		if !cacheable(n) {
+			continue
+		}
+		key := n.key()
+		if same, found := seen[key]; found {
+			replaced[n] = same
+			continue
+		}
+		seen[key] = n
+	}
+
+	root := g.root
+	if value, found := values[root]; found {
+		return graph{value: value, precision: g.precision, numbers: g.numbers}
+	}
+	if same, found := replaced[root]; found {
+		root = same
+	}
+	return graph{root: root, nodes: reachable(g.nodes, root), precision: g.precision, numbers: g.numbers}
+}
+
+// simplify применяет тождества с нулем и единицей.
+func (g graph) simplify(n *node) (*node, Number) {
+	if len(n.deps) != 2 || (n.deps[0] == nil) == (n.deps[1] == nil) {
+		return nil, nil
+	}
+	// x - операция, value - известный аргумент, left - стоит ли он слева.
+	x, value, left := n.deps[0], n.args[1], false
+	if x == nil {
+		x, value, left = n.deps[1], n.args[0], true
+	}
+	zero, one := value.Sign() == 0, value.String() == "1"
+
+	switch n.operation {
+	case "*":
+		if one {
+			return x, nil
+		}
+		if zero && g.finite(x) {
+			return nil, value
+		}
+	case "+":
+		if zero {
+			return x, nil
+		}
+	case "-", "/", "^":
+		if left {
+			break
+		}
+		if (n.operation == "-" && zero) || (n.operation != "-" && one) {
+			return x, nil
+		}
+	}
+	return nil, nil
+}
+
+// В режиме float x*0 нельзя заменить нулем: x может оказаться бесконечностью.
+func (g graph) finite(n *node) bool {
+	if g.precision != PrecisionDecimal && g.precision != PrecisionRational {
+		return false
+	}
+	switch n.operation {
+	case "+", "-", "*", OperationNeg:
+	default:
+		return false
+	}
+	for _, dep := range n.deps {
+		if dep != nil && !g.finite(dep) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *node) key() string {
+	var b strings.Builder
+	b.WriteString(n.operation)
+	for slot, dep := range n.deps {
+		if dep != nil {
+			fmt.Fprintf(&b, " #%d", dep.index)
+		} else {
+			b.WriteString(" " + n.args[slot].String())
+		}
+	}
+	return b.String()
+}
+
+func reachable(nodes []*node, root *node) []*node {
+	used := map[*node]bool{root: true}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if !used[nodes[i]] {
+			continue
+		}
+		for _, dep := range nodes[i].deps {
+			if dep != nil {
+				used[dep] = true
+			}
+		}
+	}
+
+	var result []*node
+	for _, n := range nodes {
+		if used[n] {
+			n.index = len(result)
+			n.uses = nil
+			n.pending = 0
+			result = append(result, n)
+		}
+	}
+	for _, n := range result {
+		for slot, dep := range n.deps {
+			if dep != nil {
+				dep.uses = append(dep.uses, use{n, slot})
+				n.pending++
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,200 @@
+package calc
+
+import "strings"
+
+// binaryPrecedence - сила связывания бинарных операций.
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3,
+	"!=": 3,
+	"<":  3,
+	"<=": 3,
+	">":  3,
+	">=": 3,
+	"|":  4,
+	"&":  6,
+	"<<": 8,
+	">>": 8,
+	"+":  10,
+	"-":  10,
+	"*":  20,
+	"/":  20,
+	"%":  20,
+	"//": 20,
+	"^":  40,
+}
+
+// rightAssociative - операции, которые выполняются справа налево: 2^3^2 = 2^(3^2).
+var rightAssociative = map[string]bool{
+	"^": true,
+}
+
+const unaryPrecedence = 30
+
+// Parser строит синтаксическое дерево выражения методом Пратта.
+type Parser struct {
+	tokens     []Token
+	index      int
+	expression string
+}
+
+// Parse разбирает выражение и возвращает его синтаксическое дерево.
+func Parse(expression string) (Expr, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens, expression: expression}
+	return p.parseRest()
+}
+
+// parseRest разбирает оставшиеся лексемы как одно выражение.
+func (p *Parser) parseRest() (Expr, error) {
+	if p.peek().Kind == TokenEOF {
+		return nil, p.errorAt(p.peek(), ErrEmptyExpression)
+	}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := p.peek(); token.Kind {
+	case TokenEOF:
+		return expr, nil
+	case TokenRParen:
+		return nil, p.errorAt(token, ErrMissingBracket)
+	default:
+		return nil, p.errorAt(token, ErrInvalidExpression)
+	}
+}
+
+func (p *Parser) parseExpr(minPrecedence int) (Expr, error) {
+	left, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token := p.peek()
+		precedence, isBinary := binaryPrecedence[token.Text]
+		if token.Kind != TokenOperator || !isBinary || precedence <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		if rightAssociative[token.Text] {
+			precedence--
+		}
+		right, err := p.parseExpr(precedence)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: token.Text, Left: left, Right: right, Pos: token.Pos}
+	}
+}
+
+// parsePrefix разбирает число, имя, выражение в скобках, вызов функции или унарную операцию.
+func (p *Parser) parsePrefix() (Expr, error) {
+	token := p.next()
+	switch token.Kind {
+	case TokenOperator:
+		if token.Text != "-" && token.Text != "+" && token.Text != "~" && token.Text != "!" {
+			return nil, p.errorAt(token, ErrInvalidExpression)
+		}
+		operand, err := p.parseExpr(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return unary(token, operand), nil
+	case TokenNumber:
+		text := token.Text
+		if isImaginary(text) {
+			text = strings.TrimSuffix(text, string(ImaginaryUnit))
+		}
+		value, err := parseNumber(text)
+		if err != nil {
+			return nil, p.errorAt(token, err)
+		}
+		return &NumberExpr{Value: value, Text: token.Text, Pos: token.Pos}, nil
+	case TokenIdent:
+		if p.peek().Kind != TokenLParen {
+			return &IdentExpr{Name: token.Text, Pos: token.Pos}, nil
+		}
+		args, err := p.parseArgs(p.next())
+		if err != nil {
+			return nil, err
+		}
+		return &CallExpr{Name: token.Text, Args: args, Pos: token.Pos}, nil
+	case TokenLParen:
+		expr, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		switch closing := p.next(); closing.Kind {
+		case TokenRParen:
+			return expr, nil
+		case TokenEOF, TokenSemicolon:
+			return nil, p.errorAt(token, ErrMissingBracket)
+		default:
+			return nil, p.errorAt(closing, ErrInvalidExpression)
+		}
+	}
+	return nil, p.errorAt(token, ErrInvalidExpression)
+}
+
+func (p *Parser) parseArgs(open Token) ([]Expr, error) {
+	var args []Expr
+	if p.peek().Kind == TokenRParen {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch separator := p.next(); separator.Kind {
+		case TokenComma:
+		case TokenRParen:
+			return args, nil
+		case TokenEOF, TokenSemicolon:
+			return nil, p.errorAt(open, ErrMissingBracket)
+		default:
+			return nil, p.errorAt(separator, ErrInvalidExpression)
+		}
+	}
+}
+
+// errorAt возвращает ошибку err, найденную на лексеме token.
+func (p *Parser) errorAt(token Token, err error) error {
+	return &ParseError{Err: err, Pos: token.Pos, Token: token.Text, Expression: p.expression}
+}
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.index]
+}
+
+func (p *Parser) next() Token {
+	token := p.tokens[p.index]
+	if token.Kind != TokenEOF {
+		p.index++
+	}
+	return token
+}
+
+// Минус перед числом сразу входит в константу.
+func unary(token Token, operand Expr) Expr {
+	if token.Text == "+" {
+		return operand
+	}
+	if number, ok := operand.(*NumberExpr); ok && token.Text == "-" {
+		text := "-" + number.Text
+		if strings.HasPrefix(number.Text, "-") {
+			text = number.Text[1:]
+		}
+		return &NumberExpr{Value: -number.Value, Text: text, Pos: token.Pos}
+	}
+	return &UnaryExpr{Op: token.Text, Operand: operand, Pos: token.Pos}
+}
@@ -0,0 +1,194 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// format печатает дерево со всеми скобками, чтобы проверить приоритеты.
+func format(expr Expr) string {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		return e.Text
+	case *IdentExpr:
+		return e.Name
+	case *BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", format(e.Left), e.Op, format(e.Right))
+	case *UnaryExpr:
+		return fmt.Sprintf("(%s%s)", e.Op, format(e.Operand))
+	case *CallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = format(arg)
+		}
+		return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", "))
+	}
+	return "?"
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expression string
+		tree       string
+	}{
+		{"1", "1"},
+		{"1+2*3", "(1 + (2 * 3))"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{" (1 + 2) * 3 ", "((1 + 2) * 3)"},
+		{"8/4/2", "((8 / 4) / 2)"},
+		{"2×3·4", "((2 * 3) * 4)"},
+		{"-5+3", "(-5 + 3)"},
+		{"2*-3", "(2 * -3)"},
+		{"+2 - +3", "(2 - 3)"},
+		{"-(4+1)", "(-(4 + 1))"},
+		{"-2*3", "(-2 * 3)"},
+		{"1--1", "(1 - -1)"},
+		{"2^3^2", "(2 ^ (3 ^ 2))"},
+		{"2*3^2", "(2 * (3 ^ 2))"},
+		{"-2^2", "(-(2 ^ 2))"},
+		{"2^-1", "(2 ^ -1)"},
+		{"7 % 3 + 1", "((7 % 3) + 1)"},
+		{"7//2*2", "((7 // 2) * 2)"},
+		{"sqrt(2)*max(3,4)", "(sqrt(2) * max(3, 4))"},
+		{"max(1, 2+3, -min(4))", "max(1, (2 + 3), (-min(4)))"},
+		{"f()", "f()"},
+		{"2*pi*rate", "((2 * pi) * rate)"},
+		{"-x_1", "(-x_1)"},
+		{"0xFF & (1<<4) | 0b1010", "((0xFF & (1 << 4)) | 0b1010)"},
+		{"1 | 2 & 3", "(1 | (2 & 3))"},
+		{"1 << 2 + 3", "(1 << (2 + 3))"},
+		{"~0o17 & -0x1", "((~0o17) & -0x1)"},
+		{"- -5", "5"},
+		{"(3+4i)*-2.5i", "((3 + 4i) * -2.5i)"},
+		{"2i*i", "(2i * i)"},
+		{"a > 1 && b <= 2 || !c", "(((a > 1) && (b <= 2)) || (!c))"},
+		{"x == 1 + 2", "(x == (1 + 2))"},
+		{"x & 1 != 0", "((x & 1) != 0)"},
+		{"if(x >= 10, x*2, x/2)", "if((x >= 10), (x * 2), (x / 2))"},
+	}
+	for _, c := range cases {
+		expr, err := Parse(c.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.expression, err)
+			continue
+		}
+		if tree := format(expr); tree != c.tree {
+			t.Errorf("%q: expected %s, got %s", c.expression, c.tree, tree)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		expression string
+		err        error
+	}{
+		{"", ErrEmptyExpression},
+		{"   ", ErrEmptyExpression},
+		{"1+", ErrInvalidExpression},
+		{"1 2", ErrInvalidExpression},
+		{"()", ErrInvalidExpression},
+		{"1..2", ErrInvalidExpression},
+		{"(1+2", ErrMissingBracket},
+		{"1+2)", ErrMissingBracket},
+		{"1$2", ErrIllegalSign},
+		{"*2", ErrInvalidExpression},
+		{"2*", ErrInvalidExpression},
+		{"-", ErrInvalidExpression},
+		{"sqrt 2", ErrInvalidExpression},
+		{"max(1,)", ErrInvalidExpression},
+		{"max(1 2)", ErrInvalidExpression},
+		{"max(1, 2", ErrMissingBracket},
+		{"0xZZ", ErrInvalidExpression},
+		{"0b102", ErrInvalidExpression},
+		{"0x8000000000000000", ErrIntegerOverflow},
+		{"2in", ErrInvalidExpression},
+	}
+	for _, c := range cases {
+		if _, err := Parse(c.expression); !errors.Is(err, c.err) {
+			t.Errorf("%q: expected %v, got %v", c.expression, c.err, err)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	expr, err := Parse("1 +\n 2*3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := expr.(*BinaryExpr)
+	if sum.Pos != (Pos{Offset: 2, Line: 1, Column: 3}) {
+		t.Errorf("unexpected position of +: %+v", sum.Pos)
+	}
+	product := sum.Right.(*BinaryExpr)
+	if product.Pos != (Pos{Offset: 6, Line: 2, Column: 3}) {
+		t.Errorf("unexpected position of *: %+v", product.Pos)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	cases := []struct {
+		expression string
+		err        error
+		pos        Pos
+		token      string
+		snippet    string
+	}{
+		{"2 + 3 $ 4", ErrIllegalSign, Pos{Offset: 6, Line: 1, Column: 7}, "$", "2 + 3 $ 4\n      ^"},
+		{"1 +\n\t(2 * 3", ErrMissingBracket, Pos{Offset: 5, Line: 2, Column: 2}, "(", "\t(2 * 3\n\t^"},
+		{"max(1, 2 << )", ErrInvalidExpression, Pos{Offset: 12, Line: 1, Column: 13}, ")", "max(1, 2 << )\n            ^"},
+		{"1 * ", ErrInvalidExpression, Pos{Offset: 4, Line: 1, Column: 5}, "", "1 * \n    ^"},
+		{"0x8000000000000000 + 1", ErrIntegerOverflow, Pos{Offset: 0, Line: 1, Column: 1}, "0x8000000000000000", "0x8000000000000000 + 1\n^^^^^^^^^^^^^^^^^^"},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.expression)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: expected parse error, got %v", c.expression, err)
+			continue
+		}
+		if !errors.Is(err, c.err) || parseErr.Pos != c.pos || parseErr.Token != c.token {
+			t.Errorf("%q: expected %v at %+v on %q, got %v at %+v on %q", c.expression, c.err, c.pos, c.token, parseErr.Err, parseErr.Pos, parseErr.Token)
+		}
+		if snippet := parseErr.Snippet(); snippet != c.snippet {
+			t.Errorf("%q: expected snippet\n%s\ngot\n%s", c.expression, c.snippet, snippet)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		expression string
+		canonical  string
+	}{
+		{"(1+2)*(3)", "(1 + 2) * 3"},
+		{"1-(2-3)", "1 - (2 - 3)"},
+		{"(1-2)-3", "1 - 2 - 3"},
+		{"2^(3^2)", "2 ^ 3 ^ 2"},
+		{"(2^3)^2", "(2 ^ 3) ^ 2"},
+		{"(-2)^2", "(-2) ^ 2"},
+		{"-2^2", "-2 ^ 2"},
+		{"-(4+1)*x", "-(4 + 1) * x"},
+		{"max( 1,2*  pi )", "max(1, 2 * pi)"},
+		{"~0xFF & (1<<4) | 0b1", "~0xFF & 1 << 4 | 0b1"},
+		{"!(a<b)||((c==1)&&d)", "!(a < b) || c == 1 && d"},
+	}
+	for _, c := range cases {
+		expr, err := Parse(c.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.expression, err)
+			continue
+		}
+		canonical := Format(expr)
+		if canonical != c.canonical {
+			t.Errorf("%q: expected %q, got %q", c.expression, c.canonical, canonical)
+		}
+		// Каноническая запись разбирается в то же дерево.
+		reparsed, err := Parse(canonical)
+		if err != nil || format(reparsed) != format(expr) {
+			t.Errorf("%q: canonical form %q changes the tree", c.expression, canonical)
+		}
+	}
+}
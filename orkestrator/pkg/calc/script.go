@@ -0,0 +1,149 @@
+package calc
+
+import (
+	"context"
+	"log"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+const OperationScript = "script"
+
+// ParseScript разбирает инструкции через ";", например "a = 3; b = a*2; a+b".
+func ParseScript(script string) (Expr, error) {
+	tokens, err := Tokenize(script)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens, expression: script}
+	result := &ScriptExpr{Pos: p.peek().Pos}
+	p.skipSemicolons()
+	for p.peek().Kind != TokenEOF {
+		first := p.peek()
+		statement, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		result.Statements = append(result.Statements, statement)
+
+		switch token := p.peek(); token.Kind {
+		case TokenSemicolon, TokenEOF:
+		case TokenRParen:
+			return nil, p.errorAt(token, ErrMissingBracket)
+		default:
+			return nil, p.errorAt(token, ErrInvalidExpression)
+		}
+		p.skipSemicolons()
+		if statement.Name == "" && p.peek().Kind != TokenEOF {
+			return nil, p.errorAt(first, ErrInvalidScript)
+		}
+	}
+
+	switch {
+	case len(result.Statements) == 0:
+		return nil, p.errorAt(p.peek(), ErrEmptyExpression)
+	case len(result.Statements) == 1 && result.Statements[0].Name == "":
+		return result.Statements[0].Expr, nil
+	}
+	return result, nil
+}
+
+func (p *Parser) skipSemicolons() {
+	for p.peek().Kind == TokenSemicolon {
+		p.next()
+	}
+}
+
+// parseStatement разбирает присваивание "имя = выражение" или выражение.
+func (p *Parser) parseStatement() (Statement, error) {
+	if name := p.peek(); name.Kind == TokenIdent && p.tokens[p.index+1].Kind == TokenAssign {
+		p.next()
+		p.next()
+		if err := CheckVariableName(name.Text); err != nil {
+			return Statement{}, p.errorAt(name, err)
+		}
+		expr, err := p.parseExpr(0)
+		if err != nil {
+			return Statement{}, err
+		}
+		return Statement{Name: name.Text, Expr: expr, Pos: name.Pos}, nil
+	}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return Statement{}, err
+	}
+	return Statement{Expr: expr, Pos: expr.Position()}, nil
+}
+
+// Операции всех инструкций строятся одним графом, поэтому независимые вычисляются одновременно.
+func (b *graphBuilder) script(e *ScriptExpr) (*node, Number, error) {
+	n := &node{
+		operation: OperationScript,
+		args:      make([]Number, len(e.Statements)),
+		deps:      make([]*node, len(e.Statements)),
+	}
+	for slot, statement := range e.Statements {
+		child, value, err := b.build(statement.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if statement.Name != "" {
+			if b.locals == nil {
+				b.locals = make(map[string]local)
+			}
+			b.locals[statement.Name] = local{child, value}
+		}
+		n.boolean = isBoolean(child, value)
+		if child == nil {
+			n.args[slot] = value
+			continue
+		}
+		b.link(n, slot, child)
+	}
+	b.append(n)
+	return n, nil, nil
+}
+
+// local - значение имени, присвоенного в сценарии: операция или число.
+type local struct {
+	node  *node
+	value Number
+}
+
+// Assignment - имя, которому сценарий присвоил значение, и это значение.
+type Assignment struct {
+	Name  string
+	Value Number
+}
+
+type ScriptResult struct {
+	Assignments []Assignment
+	Result      Number
+}
+
+// ResumeScript вычисляет сценарий так же, как Resume вычисляет выражение.
+func ResumeScript(ctx context.Context, script string, id string, scope Scope, done map[int]string, taskChan chan contract.TaskData, results chan contract.TaskResult) (ScriptResult, error) {
+	log.Printf("Calc: начало обработки сценария '%s' с ID=%s", script, id)
+	expr, err := ParseScript(script)
+	if err != nil {
+		return ScriptResult{}, err
+	}
+	graph, err := buildGraph(expr, scope)
+	if err != nil {
+		return ScriptResult{}, err
+	}
+	result, err := runGraph(ctx, id, graph, done, taskChan, results)
+	if err != nil {
+		return ScriptResult{}, err
+	}
+
+	scriptResult := ScriptResult{Result: result}
+	if e, ok := expr.(*ScriptExpr); ok {
+		for slot, statement := range e.Statements {
+			if statement.Name != "" {
+				scriptResult.Assignments = append(scriptResult.Assignments, Assignment{statement.Name, graph.root.args[slot]})
+			}
+		}
+	}
+	return scriptResult, nil
+}
@@ -0,0 +1,150 @@
+package calc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+// TaskStore хранит задачи, отправленные агентам, пока выражение не вычислено.
+type TaskStore struct {
+	mutex  sync.Mutex
+	lastID int
+	tasks  map[int]*contract.Task
+}
+
+func NewTaskStore() *TaskStore {
+	return &TaskStore{tasks: make(map[int]*contract.Task)}
+}
+
+// TaskJournal нужен, чтобы после перезапуска продолжить вычисление выражений.
+type TaskJournal interface {
+	SaveTask(task contract.Task) error
+	SaveResult(taskID int, result float64, exact string) error
+	DeleteTasks(expressionID string) error
+	SaveStep(expressionID string, step contract.TraceStep) error
+}
+
+type emptyJournal struct{}
+
+func (emptyJournal) SaveTask(contract.Task) error              { return nil }
+func (emptyJournal) SaveResult(int, float64, string) error     { return nil }
+func (emptyJournal) DeleteTasks(string) error                  { return nil }
+func (emptyJournal) SaveStep(string, contract.TraceStep) error { return nil }
+
+var (
+	// Tasks - задачи всех вычисляемых выражений.
+	Tasks = NewTaskStore()
+	// Journal - хранилище задач. По умолчанию задачи никуда не сохраняются.
+	Journal TaskJournal = emptyJournal{}
+)
+
+func (s *TaskStore) StartFrom(lastID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if lastID > s.lastID {
+		s.lastID = lastID
+	}
+}
+
+// Add регистрирует новую задачу и присваивает ей идентификатор.
+func (s *TaskStore) Add(task contract.Task) contract.Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastID++
+	task.ID = s.lastID
+	task.Data.ID = s.lastID
+	task.Status = contract.TaskQueued
+	task.QueuedAt = time.Now()
+	s.tasks[task.ID] = &task
+	if err := Journal.SaveTask(task); err != nil {
+		log.Printf("TaskStore: не удалось сохранить задачу %d: %v", task.ID, err)
+	}
+	return task
+}
+
+func (s *TaskStore) Lease(taskID int, deadline time.Time) (contract.Task, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	task, found := s.tasks[taskID]
+	if !found {
+		return contract.Task{}, ErrUnknownTask
+	}
+	if task.Status != contract.TaskQueued {
+		return *task, ErrTaskFinished
+	}
+	task.Status = contract.TaskLeased
+	task.Attempts++
+	task.Deadline = deadline
+	task.LeasedAt = time.Now()
+	return *task, nil
+}
+
+// Expire возвращает в очередь задачи с истекшим сроком аренды, а после maxRetries попыток - в failed.
+func (s *TaskStore) Expire(now time.Time, maxRetries int) (requeue []contract.Task, failed []contract.Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, task := range s.tasks {
+		if task.Status != contract.TaskLeased || now.Before(task.Deadline) {
+			continue
+		}
+		if task.Attempts > maxRetries {
+			task.Status = contract.TaskFailed
+			task.FinishedAt = now
+			failed = append(failed, *task)
+		} else {
+			task.Status = contract.TaskQueued
+			requeue = append(requeue, *task)
+		}
+	}
+	return requeue, failed
+}
+
+func (s *TaskStore) Finish(taskID int) (contract.Task, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	task, found := s.tasks[taskID]
+	if !found {
+		return contract.Task{}, ErrUnknownTask
+	}
+	if task.Status == contract.TaskFinished || task.Status == contract.TaskFailed {
+		return *task, ErrTaskFinished
+	}
+	task.Status = contract.TaskFinished
+	task.FinishedAt = time.Now()
+	return *task, nil
+}
+
+// Get возвращает задачу, если выражение еще вычисляется.
+func (s *TaskStore) Get(taskID int) (contract.Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	task, found := s.tasks[taskID]
+	if !found {
+		return contract.Task{}, false
+	}
+	return *task, true
+}
+
+// Forget удаляет все задачи выражения.
+func (s *TaskStore) Forget(expressionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, task := range s.tasks {
+		if task.ExpressionID == expressionID {
+			delete(s.tasks, id)
+		}
+	}
+	if err := Journal.DeleteTasks(expressionID); err != nil {
+		log.Printf("TaskStore: не удалось удалить задачи выражения %s: %v", expressionID, err)
+	}
+}
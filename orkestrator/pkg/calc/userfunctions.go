@@ -0,0 +1,237 @@
+package calc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope - режим вычисления и имена, которые можно использовать в выражении.
+type Scope struct {
+	Precision Precision
+	Variables map[string]float64
+	Functions map[string]string
+	Optimize  bool
+	FoldLimit int
+	Cache     bool
+}
+
+// UserFunction - функция пользователя, например "f(x, y) = x^2 + 2*x*y".
+type UserFunction struct {
+	Name       string
+	Params     []string
+	Body       Expr
+	Definition string
+}
+
+// ParseFunction разбирает определение функции вида "имя(параметры) = тело".
+func ParseFunction(definition string) (*UserFunction, error) {
+	tokens, err := Tokenize(definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFunction, err)
+	}
+	p := &Parser{tokens: tokens, expression: definition}
+
+	name := p.next()
+	if name.Kind != TokenIdent || p.next().Kind != TokenLParen {
+		return nil, ErrInvalidFunction
+	}
+	function := &UserFunction{Name: name.Text, Definition: strings.TrimSpace(definition)}
+	if p.peek().Kind == TokenRParen {
+		p.next()
+	} else {
+		for {
+			param := p.next()
+			if param.Kind != TokenIdent {
+				return nil, ErrInvalidFunction
+			}
+			function.Params = append(function.Params, param.Text)
+			if separator := p.next(); separator.Kind == TokenRParen {
+				break
+			} else if separator.Kind != TokenComma {
+				return nil, ErrInvalidFunction
+			}
+		}
+	}
+	if p.next().Kind != TokenAssign {
+		return nil, ErrInvalidFunction
+	}
+
+	function.Body, err = p.parseRest()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFunction, err)
+	}
+	return function, nil
+}
+
+// CheckFunction проверяет имя, параметры и тело новой функции пользователя.
+func CheckFunction(function *UserFunction, functions map[string]string) error {
+	if err := CheckVariableName(function.Name); err != nil {
+		return fmt.Errorf("%w: имя %s занято", ErrInvalidFunction, function.Name)
+	}
+	params := make(map[string]bool)
+	for _, param := range function.Params {
+		_, constant := Constants[param]
+		_, boolean := BooleanConstants[param]
+		if constant || boolean || params[param] {
+			return fmt.Errorf("%w: параметр %s", ErrInvalidFunction, param)
+		}
+		params[param] = true
+	}
+
+	var err error
+	Walk(function.Body, func(e Expr) {
+		if err != nil {
+			return
+		}
+		switch e := e.(type) {
+		case *IdentExpr:
+			_, constant := Constants[e.Name]
+			_, boolean := BooleanConstants[e.Name]
+			if !constant && !boolean && !params[e.Name] {
+				err = fmt.Errorf("%w: %s", ErrUnknownVariable, e.Name)
+			}
+		case *CallExpr:
+			if e.Name == function.Name {
+				err = fmt.Errorf("%w: %s", ErrRecursion, e.Name)
+				return
+			}
+			definition, found := functions[e.Name]
+			if !found {
+				_, err = checkCall(e.Name, len(e.Args))
+				return
+			}
+			var callee *UserFunction
+			if callee, err = ParseFunction(definition); err == nil && len(callee.Params) != len(e.Args) {
+				err = argumentCountError(callee, len(e.Args))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	withNew := make(map[string]string, len(functions)+1)
+	for name, definition := range functions {
+		withNew[name] = definition
+	}
+	withNew[function.Name] = function.Definition
+	return checkRecursion(withNew)
+}
+
+func UsedFunctions(expression string, functions map[string]string) (map[string]string, error) {
+	expr, err := ParseScript(expression)
+	if err != nil {
+		return nil, err
+	}
+	var used map[string]string
+	var visit func(e Expr)
+	visit = func(e Expr) {
+		call, ok := e.(*CallExpr)
+		if !ok {
+			return
+		}
+		definition, found := functions[call.Name]
+		if !found || used[call.Name] != "" {
+			return
+		}
+		if used == nil {
+			used = make(map[string]string)
+		}
+		used[call.Name] = definition
+		if function, err := ParseFunction(definition); err == nil {
+			Walk(function.Body, visit)
+		}
+	}
+	Walk(expr, visit)
+	return used, nil
+}
+
+// argument строится при первом использовании и один раз, сколько бы раз
+// параметр ни встречался в теле функции.
+type argument struct {
+	expr   Expr
+	locals map[string]local
+	params map[string]*argument
+	built  bool
+	node   *node
+	value  Number
+	err    error
+}
+
+func (b *graphBuilder) call(call *CallExpr, definition string) (*node, Number, error) {
+	function, err := ParseFunction(definition)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(call.Args) != len(function.Params) {
+		return nil, nil, argumentCountError(function, len(call.Args))
+	}
+	params := make(map[string]*argument, len(function.Params))
+	for i, param := range function.Params {
+		params[param] = &argument{expr: call.Args[i], locals: b.locals, params: b.params}
+	}
+
+	// В теле функции видны только ее параметры (см. CheckFunction).
+	locals, outer := b.locals, b.params
+	b.locals, b.params = nil, params
+	defer func() { b.locals, b.params = locals, outer }()
+	return b.build(function.Body)
+}
+
+// argument возвращает операцию или значение аргумента arg.
+func (b *graphBuilder) argument(arg *argument) (*node, Number, error) {
+	if !arg.built {
+		locals, params := b.locals, b.params
+		b.locals, b.params = arg.locals, arg.params
+		arg.node, arg.value, arg.err = b.build(arg.expr)
+		b.locals, b.params = locals, params
+		arg.built = true
+	}
+	return arg.node, arg.value, arg.err
+}
+
+func checkRecursion(functions map[string]string) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %s -> %s", ErrRecursion, strings.Join(path, " -> "), name)
+		case visited:
+			return nil
+		}
+		function, err := ParseFunction(functions[name])
+		if err != nil {
+			return err
+		}
+		state[name] = visiting
+		path = append(path, name)
+		Walk(function.Body, func(e Expr) {
+			if call, ok := e.(*CallExpr); ok && err == nil {
+				if _, found := functions[call.Name]; found {
+					err = visit(call.Name)
+				}
+			}
+		})
+		path = path[:len(path)-1]
+		state[name] = visited
+		return err
+	}
+
+	for name := range functions {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func argumentCountError(function *UserFunction, argCount int) error {
+	return fmt.Errorf("%w: %s ожидает %d, получает %d", ErrArgumentCount, function.Name, len(function.Params), argCount)
+}
@@ -0,0 +1,56 @@
+package calc
+
+var Constants = map[string]string{
+	"pi": "3.1415926535897932384626433832795028841971",
+	"e":  "2.7182818284590452353602874713526624977572",
+}
+
+func CheckVariableName(name string) error {
+	tokens, err := Tokenize(name)
+	if err != nil || len(tokens) != 2 || tokens[0].Kind != TokenIdent {
+		return ErrInvalidVariable
+	}
+	if _, found := Constants[name]; found {
+		return ErrInvalidVariable
+	}
+	if _, found := Functions.Lookup(name); found {
+		return ErrInvalidVariable
+	}
+	if _, found := BooleanConstants[name]; found || name == OperationIf {
+		return ErrInvalidVariable
+	}
+	return nil
+}
+
+func UsedVariables(expression string, variables map[string]float64) (map[string]float64, error) {
+	expr, err := ParseScript(expression)
+	if err != nil {
+		return nil, err
+	}
+	var used map[string]float64
+	assigned := make(map[string]bool)
+	visit := func(e Expr) {
+		ident, ok := e.(*IdentExpr)
+		if !ok || assigned[ident.Name] {
+			return
+		}
+		if value, found := variables[ident.Name]; found {
+			if used == nil {
+				used = make(map[string]float64)
+			}
+			used[ident.Name] = value
+		}
+	}
+	script, ok := expr.(*ScriptExpr)
+	if !ok {
+		Walk(expr, visit)
+		return used, nil
+	}
+	for _, statement := range script.Statements {
+		Walk(statement.Expr, visit)
+		if statement.Name != "" {
+			assigned[statement.Name] = true
+		}
+	}
+	return used, nil
+}
@@ -1,11 +1,28 @@
 package contract
 
+import (
+	"context"
+	"time"
+)
+
 type Config struct {
-	Addr                    string
-	TIME_ADDITION_MS        int
-	TIME_SUBTRACTION_MS     int
-	TIME_MULTIPLICATIONS_MS int
-	TIME_DIVISIONS_MS       int
+	Addr                      string
+	TIME_ADDITION_MS          int
+	TIME_SUBTRACTION_MS       int
+	TIME_MULTIPLICATIONS_MS   int
+	TIME_DIVISIONS_MS         int
+	TIME_INTEGER_DIVISIONS_MS int
+	TIME_MODULO_MS            int
+	TIME_EXPONENTIATIONS_MS   int
+	TASK_LEASE_MS             int
+	TASK_MAX_RETRIES          int
+	EXPRESSION_RETENTION_MS   int
+	TIME_FUNCTIONS_MS         map[string]int
+	TIME_BITWISE_MS           int
+	TIME_COMPARISON_MS        int
+	OPTIMIZE_FOLD_LIMIT       int
+	CACHE_SIZE                int
+	CACHE_TTL_MS              int
 }
 
 type TokenData struct {
@@ -30,38 +47,133 @@ type ExpressionsData struct {
 }
 
 type ExpressionData struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
+	ID            string             `json:"id"`
+	Status        ExpressionStatus   `json:"status"`
+	Result        string             `json:"result"`
+	ErrorCode     string             `json:"error_code,omitempty"`
+	ErrorMessage  string             `json:"error_message,omitempty"`
+	CreatedAt     *time.Time         `json:"created_at,omitempty"`
+	StartedAt     *time.Time         `json:"started_at,omitempty"`
+	FinishedAt    *time.Time         `json:"finished_at,omitempty"`
+	Deadline      *time.Time         `json:"deadline,omitempty"`
+	Variables     map[string]float64 `json:"variables,omitempty"`
+	Functions     map[string]string  `json:"functions,omitempty"`
+	Precision     string             `json:"precision,omitempty"`
+	Optimize      bool               `json:"optimize"`
+	SavedTasks    int                `json:"saved_tasks,omitempty"`
+	FoldLimit     int                `json:"-"`
+	Cache         bool               `json:"cache"`
+	Approximation string             `json:"approximation,omitempty"`
+	Hex           string             `json:"hex,omitempty"`
+	Complex       *Complex           `json:"complex,omitempty"`
+	Script        bool               `json:"script,omitempty"`
+	Assignments   []Assignment       `json:"assignments,omitempty"`
+}
+
+type Assignment struct {
+	Name   string `json:"name"`
 	Result string `json:"result"`
 }
 
+type Complex struct {
+	Real float64 `json:"real"`
+	Imag float64 `json:"imag"`
+}
+
+type Variable struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+type VariablesData struct {
+	Variables []Variable `json:"variables"`
+}
+
+type Function struct {
+	Name       string   `json:"name"`
+	Params     []string `json:"params"`
+	Definition string   `json:"definition"`
+}
+
+type FunctionsData struct {
+	Functions []Function `json:"functions"`
+}
+
 type TaskData struct {
-	ID            int     `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID            int       `json:"id"`
+	Arg1          float64   `json:"arg1"`
+	Arg2          float64   `json:"arg2"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	Args          []float64 `json:"args,omitempty"`
+	Precision     string    `json:"precision,omitempty"`
+	ExactArgs     []string  `json:"exact_args,omitempty"`
+	IntArgs       []int64   `json:"int_args,omitempty"`
+	ComplexArgs   []Complex `json:"complex_args,omitempty"`
+}
+
+type Task struct {
+	ID           int
+	ExpressionID string
+	Node         int
+	Parent       int
+	Slot         int
+	Status       string
+	Attempts     int
+	Deadline     time.Time
+	Data         TaskData
+	QueuedAt     time.Time
+	LeasedAt     time.Time
+	FinishedAt   time.Time
 }
 
 type TaskResult struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+	ID        int      `json:"id"`
+	Result    float64  `json:"result"`
+	Exact     string   `json:"exact,omitempty"`
+	IntResult int64    `json:"int_result,omitempty"`
+	Overflow  bool     `json:"overflow,omitempty"`
+	Complex   *Complex `json:"complex,omitempty"`
+	Agent     string   `json:"agent,omitempty"`
+	AI        bool     `json:"ai,omitempty"`
+	Err       error    `json:"-"`
+}
+
+type TraceStep struct {
+	TaskID    int      `json:"task_id"`
+	Operation string   `json:"operation"`
+	Operands  []string `json:"operands"`
+	Result    string   `json:"result,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Cached    bool     `json:"cached,omitempty"`
+	Agent     string   `json:"agent"`
+	Attempts  int      `json:"attempts"`
+	WaitMs    int64    `json:"wait_ms"`
+	ComputeMs int64    `json:"compute_ms"`
+}
+
+type TraceData struct {
+	ID    string      `json:"id"`
+	Steps []TraceStep `json:"steps"`
 }
 
 type ExpressionMapData struct {
 	User    string
 	Data    ExpressionData
-	ExpChan chan float64
+	ExpChan chan TaskResult
+	Ctx     context.Context
+	Cancel  context.CancelFunc
 }
 
 const CalcServerSecret = "calc_server_signature"
 const TokenExpiredTimeHours = 24
 
 var (
-	InProcess     = "IN PROGRESS"
-	Done          = "DONE"
-	Undefined     = "UNKNOWN"
-	AppConfig     *Config
-	ExpressionMap = make(map[string]ExpressionMapData)
-	TaskChannel   = make(chan TaskData, 100)
+	Undefined    = "UNKNOWN"
+	TaskQueued   = "QUEUED"
+	TaskLeased   = "LEASED"
+	TaskFinished = "FINISHED"
+	TaskFailed   = "FAILED"
+	AppConfig    *Config
+	TaskChannel  = make(chan TaskData, 100)
 )
@@ -0,0 +1,30 @@
+package contract
+
+type ExpressionStatus string
+
+const (
+	StatusQueued    ExpressionStatus = "QUEUED"
+	StatusRunning   ExpressionStatus = "RUNNING"
+	StatusDone      ExpressionStatus = "DONE"
+	StatusFailed    ExpressionStatus = "FAILED"
+	StatusCancelled ExpressionStatus = "CANCELLED"
+)
+
+var expressionTransitions = map[ExpressionStatus][]ExpressionStatus{
+	StatusQueued:  {StatusRunning, StatusFailed, StatusCancelled},
+	StatusRunning: {StatusDone, StatusFailed, StatusCancelled},
+}
+
+// Из DONE, FAILED и CANCELLED перейти никуда нельзя.
+func (s ExpressionStatus) CanBecome(next ExpressionStatus) bool {
+	for _, allowed := range expressionTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ExpressionStatus) Final() bool {
+	return len(expressionTransitions[s]) == 0
+}
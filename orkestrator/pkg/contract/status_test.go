@@ -0,0 +1,30 @@
+package contract
+
+import "testing"
+
+func TestExpressionStatusTransitions(t *testing.T) {
+	cases := []struct {
+		from, to ExpressionStatus
+		allowed  bool
+	}{
+		{StatusQueued, StatusRunning, true},
+		{StatusQueued, StatusCancelled, true},
+		{StatusQueued, StatusDone, false},
+		{StatusRunning, StatusDone, true},
+		{StatusRunning, StatusFailed, true},
+		{StatusRunning, StatusQueued, false},
+		{StatusDone, StatusFailed, false},
+		{StatusFailed, StatusDone, false},
+		{StatusCancelled, StatusRunning, false},
+	}
+	for _, c := range cases {
+		if c.from.CanBecome(c.to) != c.allowed {
+			t.Errorf("%s -> %s: expected allowed=%v", c.from, c.to, c.allowed)
+		}
+	}
+	for _, status := range []ExpressionStatus{StatusDone, StatusFailed, StatusCancelled} {
+		if !status.Final() {
+			t.Errorf("%s must be final", status)
+		}
+	}
+}
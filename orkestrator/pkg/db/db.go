@@ -19,11 +19,68 @@ type (
 	}
 
 	Expression struct {
-		ID         int64
-		Expression string
+		ID           int64
+		Expression   string
+		UserID       int64
+		Status       string
+		Result       string
+		ErrorCode    string
+		ErrorMessage string
+		CreatedAt    sql.NullTime
+		StartedAt    sql.NullTime
+		FinishedAt   sql.NullTime
+		Deadline     sql.NullTime
+		Variables    string
+		Functions    string
+		Precision    string
+		// От Optimize и FoldLimit зависят номера операций в таблице tasks.
+		Optimize    bool
+		FoldLimit   int
+		SavedTasks  int
+		Cache       bool
+		Script      bool
+		Assignments string
+	}
+
+	Task struct {
+		ID            int64
+		ExpressionID  int64
+		Node          int
+		Parent        int
+		Slot          int
+		Arg1          float64
+		Arg2          float64
+		Operation     string
+		OperationTime int
+		Status        string
+		Result        float64
+		ExactResult   string
+	}
+
+	TraceStep struct {
+		ExpressionID int64
+		TaskID       int64
+		Operation    string
+		Operands     string
+		Result       string
+		Error        string
+		Cached       bool
+		Agent        string
+		Attempts     int
+		WaitMs       int64
+		ComputeMs    int64
+	}
+
+	Variable struct {
+		UserID int64
+		Name   string
+		Value  float64
+	}
+
+	Function struct {
 		UserID     int64
-		Status     string
-		Result     string
+		Name       string
+		Definition string
 	}
 )
 
@@ -46,9 +103,86 @@ func createTables(ctx context.Context, db *sql.DB) error {
 		user_id INTEGER NOT NULL,
 		status TEXT NOT NULL,
 		result TEXT NOT NULL,
+		error_code TEXT NOT NULL DEFAULT '',
+		error_message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		started_at DATETIME,
+		finished_at DATETIME,
+		deadline DATETIME,
+		variables TEXT NOT NULL DEFAULT '',
+		functions TEXT NOT NULL DEFAULT '',
+		precision TEXT NOT NULL DEFAULT '',
+		optimize INTEGER NOT NULL DEFAULT 0,
+		fold_limit INTEGER NOT NULL DEFAULT 0,
+		saved_tasks INTEGER NOT NULL DEFAULT 0,
+		cache INTEGER NOT NULL DEFAULT 0,
+		script INTEGER NOT NULL DEFAULT 0,
+		assignments TEXT NOT NULL DEFAULT '',
 	
 		FOREIGN KEY (user_id)  REFERENCES expressions (id)
 	);`
+
+		tasksTable = `
+	CREATE TABLE IF NOT EXISTS tasks(
+		id INTEGER PRIMARY KEY,
+		expression_id INTEGER NOT NULL,
+		node INTEGER NOT NULL,
+		parent INTEGER NOT NULL,
+		slot INTEGER NOT NULL,
+		arg1 REAL NOT NULL,
+		arg2 REAL NOT NULL,
+		operation TEXT NOT NULL,
+		operation_time INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		result REAL NOT NULL DEFAULT 0,
+		exact_result TEXT NOT NULL DEFAULT '',
+
+		FOREIGN KEY (expression_id)  REFERENCES expressions (id)
+	);`
+
+		variablesTable = `
+	CREATE TABLE IF NOT EXISTS variables(
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+
+		PRIMARY KEY (user_id, name),
+		FOREIGN KEY (user_id)  REFERENCES users (id)
+	);`
+
+		traceTable = `
+	CREATE TABLE IF NOT EXISTS trace(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		expression_id INTEGER NOT NULL,
+		task_id INTEGER NOT NULL,
+		operation TEXT NOT NULL,
+		operands TEXT NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL,
+		cached INTEGER NOT NULL DEFAULT 0,
+		agent TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		wait_ms INTEGER NOT NULL,
+		compute_ms INTEGER NOT NULL,
+
+		FOREIGN KEY (expression_id)  REFERENCES expressions (id)
+	);`
+
+		taskIdsTable = `
+	CREATE TABLE IF NOT EXISTS task_ids(
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_id INTEGER NOT NULL
+	);`
+
+		functionsTable = `
+	CREATE TABLE IF NOT EXISTS functions(
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		definition TEXT NOT NULL,
+
+		PRIMARY KEY (user_id, name),
+		FOREIGN KEY (user_id)  REFERENCES users (id)
+	);`
 	)
 
 	if _, err := db.ExecContext(ctx, usersTable); err != nil {
@@ -59,6 +193,99 @@ func createTables(ctx context.Context, db *sql.DB) error {
 		return err
 	}
 
+	if _, err := db.ExecContext(ctx, tasksTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, traceTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, taskIdsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, variablesTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, functionsTable); err != nil {
+		return err
+	}
+
+	if err := migrateExpressions(ctx, db); err != nil {
+		return err
+	}
+
+	err := addColumns(ctx, db, "tasks", []column{
+		{"exact_result", "TEXT NOT NULL DEFAULT ''"},
+	})
+	if err != nil {
+		return err
+	}
+
+	return addColumns(ctx, db, "trace", []column{
+		{"cached", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+type column struct {
+	name       string
+	definition string
+}
+
+// migrateExpressions обновляет таблицу expressions прежних версий сервера.
+func migrateExpressions(ctx context.Context, db *sql.DB) error {
+	err := addColumns(ctx, db, "expressions", []column{
+		{"error_code", "TEXT NOT NULL DEFAULT ''"},
+		{"error_message", "TEXT NOT NULL DEFAULT ''"},
+		{"created_at", "DATETIME"},
+		{"started_at", "DATETIME"},
+		{"finished_at", "DATETIME"},
+		{"deadline", "DATETIME"},
+		{"variables", "TEXT NOT NULL DEFAULT ''"},
+		{"functions", "TEXT NOT NULL DEFAULT ''"},
+		{"precision", "TEXT NOT NULL DEFAULT ''"},
+		{"optimize", "INTEGER NOT NULL DEFAULT 0"},
+		{"fold_limit", "INTEGER NOT NULL DEFAULT 0"},
+		{"saved_tasks", "INTEGER NOT NULL DEFAULT 0"},
+		{"cache", "INTEGER NOT NULL DEFAULT 0"},
+		{"script", "INTEGER NOT NULL DEFAULT 0"},
+		{"assignments", "TEXT NOT NULL DEFAULT ''"},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE expressions SET status = $1 WHERE status = 'IN PROGRESS'", string(contract.StatusQueued))
+	return err
+}
+
+func addColumns(ctx context.Context, db *sql.DB, table string, columns []column) error {
+	existing := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info($1)", table)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		if existing[column.name] {
+			continue
+		}
+		q := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.name, column.definition)
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -103,10 +330,14 @@ func CheckUser(user *contract.UserLogin) bool {
 
 func InsertExpression(expression *Expression) (int64, error) {
 	var q = `
-	INSERT INTO expressions (expression, user_id, status, result) values ($1, $2, $3, $4)
+	INSERT INTO expressions (expression, user_id, status, result, created_at, deadline, variables, functions, precision,
+		optimize, fold_limit, saved_tasks, cache, script)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
-	result, err := db.ExecContext(ctx, q, expression.Expression, expression.UserID, expression.Status, expression.Result)
+	result, err := db.ExecContext(ctx, q, expression.Expression, expression.UserID, expression.Status, expression.Result,
+		expression.CreatedAt, expression.Deadline, expression.Variables, expression.Functions, expression.Precision,
+		expression.Optimize, expression.FoldLimit, expression.SavedTasks, expression.Cache, expression.Script)
 	if err != nil {
 		return 0, err
 	}
@@ -153,7 +384,7 @@ func selectUsers(ctx context.Context, db *sql.DB) ([]User, error) {
 
 func SelectExpressionsForUserId(userId int64) ([]Expression, error) {
 	var expressions []Expression
-	var q = "SELECT id, expression, user_id, status, result FROM expressions WHERE user_id = $1"
+	var q = "SELECT " + expressionColumns + " FROM expressions WHERE user_id = $1"
 
 	rows, err := db.QueryContext(ctx, q, userId)
 	if err != nil {
@@ -162,8 +393,7 @@ func SelectExpressionsForUserId(userId int64) ([]Expression, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		e := Expression{}
-		err := rows.Scan(&e.ID, &e.Expression, &e.UserID, &e.Status, &e.Result)
+		e, err := scanExpression(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -173,27 +403,35 @@ func SelectExpressionsForUserId(userId int64) ([]Expression, error) {
 	return expressions, nil
 }
 
-func SelectExpressionForId(id int64) (Expression, error) {
-	var u Expression
-	var q = "SELECT id, expression, user_id, status, result FROM expressions WHERE id = $1"
+const expressionColumns = "id, expression, user_id, status, result, error_code, error_message, created_at, started_at, finished_at, deadline, variables, functions, precision, optimize, fold_limit, saved_tasks, cache, script, assignments"
 
-	err := db.QueryRowContext(ctx, q, id).Scan(&u.ID, &u.Expression, &u.UserID, &u.Status, &u.Result)
+func scanExpression(row interface{ Scan(...any) error }) (Expression, error) {
+	e := Expression{}
+	err := row.Scan(&e.ID, &e.Expression, &e.UserID, &e.Status, &e.Result,
+		&e.ErrorCode, &e.ErrorMessage, &e.CreatedAt, &e.StartedAt, &e.FinishedAt, &e.Deadline, &e.Variables, &e.Functions, &e.Precision,
+		&e.Optimize, &e.FoldLimit, &e.SavedTasks, &e.Cache, &e.Script, &e.Assignments)
+	return e, err
+}
 
-	if err != nil {
-		return u, err
-	}
+func SelectExpressionForId(id int64) (Expression, error) {
+	var q = "SELECT " + expressionColumns + " FROM expressions WHERE id = $1"
 
-	return u, nil
+	return scanExpression(db.QueryRowContext(ctx, q, id))
 }
 
-func UpdateExpressionStatusResult(id int64, newStatus string, newResult string) error {
-	var q = "UPDATE expressions SET status = $1, result = $2 WHERE id = $3"
+func UpdateExpressionState(expression *Expression) error {
+	var q = `
+	UPDATE expressions SET status = $1, result = $2, error_code = $3, error_message = $4, started_at = $5, finished_at = $6,
+		assignments = $7
+	WHERE id = $8
+	`
 
 	if ctx.Err() != nil {
 		return fmt.Errorf("контекст истек: %w", ctx.Err())
 	}
 
-	_, err := db.ExecContext(ctx, q, newStatus, newResult, id)
+	_, err := db.ExecContext(ctx, q, expression.Status, expression.Result, expression.ErrorCode, expression.ErrorMessage,
+		expression.StartedAt, expression.FinishedAt, expression.Assignments, expression.ID)
 	if err != nil {
 		return fmt.Errorf("ошибка выполнения запроса: %w", err)
 	}
@@ -201,6 +439,156 @@ func UpdateExpressionStatusResult(id int64, newStatus string, newResult string)
 	return nil
 }
 
+func SelectExpressionsForStatus(status string) ([]Expression, error) {
+	var expressions []Expression
+	var q = "SELECT " + expressionColumns + " FROM expressions WHERE status = $1"
+
+	rows, err := db.QueryContext(ctx, q, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, e)
+	}
+
+	return expressions, nil
+}
+
+func InsertTask(task *Task) error {
+	var q = `
+	INSERT INTO tasks (id, expression_id, node, parent, slot, arg1, arg2, operation, operation_time, status)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	// Строки tasks удаляются вместе с выражением, а номера задач не должны повторяться.
+	var qId = `
+	INSERT INTO task_ids (id, last_id) values (1, $1)
+	ON CONFLICT (id) DO UPDATE SET last_id = MAX(last_id, excluded.last_id)
+	`
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, q, task.ID, task.ExpressionID, task.Node, task.Parent, task.Slot,
+		task.Arg1, task.Arg2, task.Operation, task.OperationTime, task.Status)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, qId, task.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func UpdateTaskStatusResult(id int64, newStatus string, newResult float64, exactResult string) error {
+	var q = "UPDATE tasks SET status = $1, result = $2, exact_result = $3 WHERE id = $4"
+
+	_, err := db.ExecContext(ctx, q, newStatus, newResult, exactResult, id)
+	return err
+}
+
+func DeleteTasksForExpressionId(expressionId int64) error {
+	var q = "DELETE FROM tasks WHERE expression_id = $1"
+
+	_, err := db.ExecContext(ctx, q, expressionId)
+	return err
+}
+
+func SelectTasksForExpressionId(expressionId int64) ([]Task, error) {
+	var tasks []Task
+	var q = `
+	SELECT id, expression_id, node, parent, slot, arg1, arg2, operation, operation_time, status, result, exact_result
+	FROM tasks WHERE expression_id = $1 ORDER BY id
+	`
+
+	rows, err := db.QueryContext(ctx, q, expressionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := Task{}
+		err := rows.Scan(&t.ID, &t.ExpressionID, &t.Node, &t.Parent, &t.Slot,
+			&t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime, &t.Status, &t.Result, &t.ExactResult)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, nil
+}
+
+func InsertTraceStep(step *TraceStep) error {
+	var q = `
+	INSERT INTO trace (expression_id, task_id, operation, operands, result, error, cached, agent, attempts, wait_ms, compute_ms)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	_, err := db.ExecContext(ctx, q, step.ExpressionID, step.TaskID, step.Operation, step.Operands,
+		step.Result, step.Error, step.Cached, step.Agent, step.Attempts, step.WaitMs, step.ComputeMs)
+	return err
+}
+
+func SelectTraceForExpressionId(expressionId int64) ([]TraceStep, error) {
+	var steps []TraceStep
+	var q = `
+	SELECT expression_id, task_id, operation, operands, result, error, cached, agent, attempts, wait_ms, compute_ms
+	FROM trace WHERE expression_id = $1 ORDER BY id
+	`
+
+	rows, err := db.QueryContext(ctx, q, expressionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := TraceStep{}
+		err := rows.Scan(&s.ExpressionID, &s.TaskID, &s.Operation, &s.Operands, &s.Result,
+			&s.Error, &s.Cached, &s.Agent, &s.Attempts, &s.WaitMs, &s.ComputeMs)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+
+	return steps, nil
+}
+
+func SelectLastTaskId() (int64, error) {
+	var id int64
+	// В базах прежних версий task_ids пуста.
+	var q = `
+	SELECT MAX(
+		COALESCE((SELECT last_id FROM task_ids), 0),
+		COALESCE((SELECT MAX(id) FROM tasks), 0),
+		COALESCE((SELECT MAX(task_id) FROM trace), 0))
+	`
+
+	err := db.QueryRowContext(ctx, q).Scan(&id)
+	return id, err
+}
+
+func SelectLoginForUserId(userId int64) (string, error) {
+	u, err := selectUserByID(ctx, db, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Login, nil
+}
+
 func selectExpressions(ctx context.Context, db *sql.DB) ([]Expression, error) {
 	var expressions []Expression
 	var q = "SELECT id, expression, user_id FROM expressions"
@@ -264,3 +652,89 @@ func CreateDb() {
 		panic(err)
 	}
 }
+
+func UpsertVariable(variable *Variable) error {
+	var q = `
+	INSERT INTO variables (user_id, name, value) values ($1, $2, $3)
+	ON CONFLICT (user_id, name) DO UPDATE SET value = excluded.value
+	`
+
+	_, err := db.ExecContext(ctx, q, variable.UserID, variable.Name, variable.Value)
+	return err
+}
+
+func SelectVariablesForUserId(userId int64) ([]Variable, error) {
+	var variables []Variable
+	var q = "SELECT user_id, name, value FROM variables WHERE user_id = $1 ORDER BY name"
+
+	rows, err := db.QueryContext(ctx, q, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := Variable{}
+		err := rows.Scan(&v.UserID, &v.Name, &v.Value)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, v)
+	}
+
+	return variables, nil
+}
+
+func DeleteVariable(userId int64, name string) (bool, error) {
+	var q = "DELETE FROM variables WHERE user_id = $1 AND name = $2"
+
+	result, err := db.ExecContext(ctx, q, userId, name)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+func UpsertFunction(function *Function) error {
+	var q = `
+	INSERT INTO functions (user_id, name, definition) values ($1, $2, $3)
+	ON CONFLICT (user_id, name) DO UPDATE SET definition = excluded.definition
+	`
+
+	_, err := db.ExecContext(ctx, q, function.UserID, function.Name, function.Definition)
+	return err
+}
+
+func SelectFunctionsForUserId(userId int64) ([]Function, error) {
+	var functions []Function
+	var q = "SELECT user_id, name, definition FROM functions WHERE user_id = $1 ORDER BY name"
+
+	rows, err := db.QueryContext(ctx, q, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := Function{}
+		err := rows.Scan(&f.UserID, &f.Name, &f.Definition)
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, f)
+	}
+
+	return functions, nil
+}
+
+func DeleteFunction(userId int64, name string) (bool, error) {
+	var q = "DELETE FROM functions WHERE user_id = $1 AND name = $2"
+
+	result, err := db.ExecContext(ctx, q, userId, name)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
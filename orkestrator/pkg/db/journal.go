@@ -0,0 +1,69 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+// TaskJournal сохраняет задачи выражений в таблицу tasks.
+type TaskJournal struct{}
+
+func (TaskJournal) SaveTask(task contract.Task) error {
+	expressionId, err := strconv.ParseInt(task.ExpressionID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return InsertTask(&Task{
+		ID:            int64(task.ID),
+		ExpressionID:  expressionId,
+		Node:          task.Node,
+		Parent:        task.Parent,
+		Slot:          task.Slot,
+		Arg1:          task.Data.Arg1,
+		Arg2:          task.Data.Arg2,
+		Operation:     task.Data.Operation,
+		OperationTime: task.Data.OperationTime,
+		Status:        task.Status,
+	})
+}
+
+func (TaskJournal) SaveResult(taskID int, result float64, exact string) error {
+	return UpdateTaskStatusResult(int64(taskID), contract.TaskFinished, result, exact)
+}
+
+func (TaskJournal) DeleteTasks(expressionID string) error {
+	expressionId, err := strconv.ParseInt(expressionID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return DeleteTasksForExpressionId(expressionId)
+}
+
+func (TaskJournal) SaveStep(expressionID string, step contract.TraceStep) error {
+	expressionId, err := strconv.ParseInt(expressionID, 10, 64)
+	if err != nil {
+		return err
+	}
+	operands, err := json.Marshal(step.Operands)
+	if err != nil {
+		return err
+	}
+
+	return InsertTraceStep(&TraceStep{
+		ExpressionID: expressionId,
+		TaskID:       int64(step.TaskID),
+		Operation:    step.Operation,
+		Operands:     string(operands),
+		Result:       step.Result,
+		Error:        step.Error,
+		Cached:       step.Cached,
+		Agent:        step.Agent,
+		Attempts:     step.Attempts,
+		WaitMs:       step.WaitMs,
+		ComputeMs:    step.ComputeMs,
+	})
+}
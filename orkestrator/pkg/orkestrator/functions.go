@@ -0,0 +1,106 @@
+package orkestrator
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+	"github.com/veronicashkarova/server-for-calc/pkg/db"
+)
+
+// Выражения, принятые раньше, продолжают использовать прежнее определение.
+func DefineFunction(userLogin string, definition string) (contract.Function, error) {
+	function, err := calc.ParseFunction(definition)
+	if err != nil {
+		return contract.Function{}, err
+	}
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return contract.Function{}, err
+	}
+	functions, err := userFunctions(userId)
+	if err != nil {
+		return contract.Function{}, err
+	}
+	if err := calc.CheckFunction(function, functions); err != nil {
+		return contract.Function{}, err
+	}
+
+	err = db.UpsertFunction(&db.Function{UserID: userId, Name: function.Name, Definition: function.Definition})
+	if err != nil {
+		return contract.Function{}, err
+	}
+	return contractFunction(function), nil
+}
+
+func UserFunctions(userLogin string) (string, error) {
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return "", err
+	}
+	functions, err := db.SelectFunctionsForUserId(userId)
+	if err != nil {
+		return "", err
+	}
+
+	data := contract.FunctionsData{Functions: []contract.Function{}}
+	for _, f := range functions {
+		function, err := calc.ParseFunction(f.Definition)
+		if err != nil {
+			log.Printf("UserFunctions: не удалось разобрать функцию %s: %v", f.Name, err)
+			continue
+		}
+		data.Functions = append(data.Functions, contractFunction(function))
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes), nil
+}
+
+func DeleteFunction(userLogin string, name string) error {
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return err
+	}
+	deleted, err := db.DeleteFunction(userId, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return calc.ErrFunctionNotFound
+	}
+	return nil
+}
+
+func userFunctions(userId int64) (map[string]string, error) {
+	functions, err := db.SelectFunctionsForUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]string, len(functions))
+	for _, function := range functions {
+		definitions[function.Name] = function.Definition
+	}
+	return definitions, nil
+}
+
+func usedFunctions(userId int64, expression string) map[string]string {
+	functions, err := userFunctions(userId)
+	if err != nil {
+		log.Printf("usedFunctions: не удалось получить функции пользователя %d: %v", userId, err)
+		return nil
+	}
+	used, _ := calc.UsedFunctions(expression, functions)
+	return used
+}
+
+func contractFunction(function *calc.UserFunction) contract.Function {
+	params := function.Params
+	if params == nil {
+		params = []string{}
+	}
+	return contract.Function{Name: function.Name, Params: params, Definition: function.Definition}
+}
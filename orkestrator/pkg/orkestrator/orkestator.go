@@ -1,9 +1,11 @@
 package orkestrator
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
@@ -40,17 +42,78 @@ func LoginUser(user *contract.UserLogin) (string, error) {
 	return string(jsonBytes), nil
 }
 
-func AddExpression(userLogin string, expression string) (string, string, error) {
+type Orkestrator struct {
+	registry *Registry
+}
+
+func New(registry *Registry) *Orkestrator {
+	return &Orkestrator{registry: registry}
+}
+
+// AddExpression возвращает *calc.ParseError, если в выражении синтаксическая ошибка.
+func (o *Orkestrator) AddExpression(userLogin string, expression string, precision string, optimize bool, cache bool, deadline *time.Time) (string, string, error) {
+	return o.addExpression(userLogin, expression, false, precision, optimize, cache, deadline)
+}
+
+// AddScript принимает сценарий, например "a = 3; b = a*2; a+b".
+func (o *Orkestrator) AddScript(userLogin string, script string, precision string, optimize bool, cache bool, deadline *time.Time) (string, string, error) {
+	return o.addExpression(userLogin, script, true, precision, optimize, cache, deadline)
+}
+
+func (o *Orkestrator) addExpression(userLogin string, expression string, script bool, precision string, optimize bool, cache bool, deadline *time.Time) (string, string, error) {
 	var id int64
+	createdAt := time.Now()
+	if deadline != nil && !deadline.After(createdAt) {
+		return "", "", calc.ErrInvalidDeadline
+	}
+	mode, err := calc.ParsePrecision(precision)
+	if err != nil {
+		return "", "", err
+	}
+	// Синтаксические ошибки сообщаются сразу, с местом ошибки в выражении.
+	parse := calc.Parse
+	if script {
+		parse = calc.ParseScript
+	}
+	if _, err := parse(expression); err != nil {
+		return "", "", err
+	}
+	if mode == calc.PrecisionFloat {
+		precision = ""
+	}
+	var variables map[string]float64
+	var functions map[string]string
+	foldLimit := contract.AppConfig.OPTIMIZE_FOLD_LIMIT
+	savedTasks := 0
 	userId, err := db.SelectIdForUser(userLogin)
 
 	if err == nil {
+		variables = usedVariables(userId, expression)
+		functions = usedFunctions(userId, expression)
+		// Ошибки в выражении сообщит его вычисление.
+		savedTasks, _ = calc.SavedTasks(expression, calc.Scope{
+			Precision: mode,
+			Variables: variables,
+			Functions: functions,
+			Optimize:  optimize,
+			FoldLimit: foldLimit,
+		})
 		dbExpression := db.Expression{
 			ID:         int64(id),
 			Expression: expression,
 			UserID:     userId,
-			Status:     contract.InProcess,
+			Status:     string(contract.StatusQueued),
 			Result:     contract.Undefined,
+			CreatedAt:  nullTime(&createdAt),
+			Deadline:   nullTime(deadline),
+			Variables:  encodeNames(variables),
+			Functions:  encodeNames(functions),
+			Precision:  precision,
+			Optimize:   optimize,
+			FoldLimit:  foldLimit,
+			SavedTasks: savedTasks,
+			Cache:      cache,
+			Script:     script,
 		}
 		id, err = db.InsertExpression(&dbExpression)
 		if err != nil {
@@ -61,16 +124,29 @@ func AddExpression(userLogin string, expression string) (string, string, error)
 	newId := strconv.Itoa(int(id))
 	expressionData :=
 		contract.ExpressionData{
-			ID:     newId,
-			Status: contract.InProcess,
-			Result: contract.Undefined,
+			ID:         newId,
+			Status:     contract.StatusQueued,
+			Result:     contract.Undefined,
+			CreatedAt:  &createdAt,
+			Deadline:   deadline,
+			Variables:  variables,
+			Functions:  functions,
+			Precision:  precision,
+			Optimize:   optimize,
+			SavedTasks: savedTasks,
+			FoldLimit:  foldLimit,
+			Cache:      cache,
+			Script:     script,
 		}
 
-	contract.ExpressionMap[newId] = contract.ExpressionMapData{
+	ctx, cancel := expressionContext(deadline)
+	o.registry.Add(newId, contract.ExpressionMapData{
 		User:    userLogin,
 		Data:    expressionData,
-		ExpChan: make(chan float64),
-	}
+		ExpChan: make(chan contract.TaskResult),
+		Ctx:     ctx,
+		Cancel:  cancel,
+	})
 
 	response := contract.ResponseData{ID: newId}
 	jsonBytes, err := json.Marshal(response)
@@ -82,6 +158,154 @@ func AddExpression(userLogin string, expression string) (string, string, error)
 
 }
 
+// ValidateExpression проверяет выражение, ничего не сохраняя и не отправляя агентам.
+func (o *Orkestrator) ValidateExpression(userLogin string, expression string, precision string, optimize bool) (calc.Plan, error) {
+	mode, err := calc.ParsePrecision(precision)
+	if err != nil {
+		return calc.Plan{}, err
+	}
+	scope := calc.Scope{Precision: mode, Optimize: optimize, FoldLimit: contract.AppConfig.OPTIMIZE_FOLD_LIMIT}
+	if userId, err := db.SelectIdForUser(userLogin); err == nil {
+		scope.Variables = usedVariables(userId, expression)
+		scope.Functions = usedFunctions(userId, expression)
+	}
+	return calc.Validate(expression, scope)
+}
+
+func (o *Orkestrator) CalculateExpression(id string, expression string, done map[int]string) {
+	value, exist := o.registry.Get(id)
+	if !exist {
+		log.Printf("CalculateExpression: выражение ID=%s не найдено в реестре", id)
+		return
+	}
+	if value.Data.Status.Final() {
+		log.Printf("CalculateExpression: выражение ID=%s уже завершено (%s)", id, value.Data.Status)
+		return
+	}
+	if value.Data.Status == contract.StatusQueued {
+		if _, err := o.setExpressionStatus(id, contract.StatusRunning, contract.Undefined, nil); err != nil {
+			log.Printf("CalculateExpression: не удалось запустить выражение ID=%s: %v", id, err)
+			return
+		}
+	}
+
+	defer value.Cancel()
+
+	log.Printf("CalculateExpression: запуск calc.Calc для выражения %s с ID=%s", expression, id)
+	var result calc.Number
+	var err error
+	if value.Data.Script {
+		var scriptResult calc.ScriptResult
+		scriptResult, err = calc.ResumeScript(value.Ctx, expression, id, expressionScope(value.Data), done, contract.TaskChannel, value.ExpChan)
+		result = scriptResult.Result
+		if err == nil {
+			o.registry.SetAssignments(id, scriptAssignments(scriptResult))
+		}
+	} else {
+		result, err = calc.Resume(value.Ctx, expression, id, expressionScope(value.Data), done, contract.TaskChannel, value.ExpChan)
+	}
+	log.Printf("CalculateExpression: calc.Calc завершился для ID=%s, result=%v, err=%v", id, result, err)
+	if errors.Is(err, calc.ErrCancelled) {
+		// Статус CANCELLED уже сохранил CancelExpression.
+		return
+	}
+	if err != nil {
+		log.Printf("CalculateExpression: ошибка вычисления для ID=%s: %v", id, err)
+		_, err = o.setExpressionStatus(id, contract.StatusFailed, contract.Undefined, err)
+	} else {
+		log.Printf("CalculateExpression: вычисление успешно для ID=%s, результат=%s", id, result)
+		_, err = o.setExpressionStatus(id, contract.StatusDone, calc.FormatResult(result), nil)
+	}
+	if err != nil {
+		log.Printf("CalculateExpression: не удалось сохранить результат выражения ID=%s: %v", id, err)
+	}
+}
+
+// ResumeExpressions продолжает вычисление выражений после перезапуска.
+func (o *Orkestrator) ResumeExpressions() error {
+	lastTaskId, err := db.SelectLastTaskId()
+	if err != nil {
+		return err
+	}
+	calc.Tasks.StartFrom(int(lastTaskId))
+
+	var expressions []db.Expression
+	for _, status := range []contract.ExpressionStatus{contract.StatusQueued, contract.StatusRunning} {
+		selected, err := db.SelectExpressionsForStatus(string(status))
+		if err != nil {
+			return err
+		}
+		expressions = append(expressions, selected...)
+	}
+
+	for _, expression := range expressions {
+		tasks, err := db.SelectTasksForExpressionId(expression.ID)
+		if err != nil {
+			return err
+		}
+		done := make(map[int]string)
+		for _, task := range tasks {
+			if task.Status != contract.TaskFinished {
+				continue
+			}
+			// Задачи, сохраненные до появления exact_result, хранят только result.
+			done[task.Node] = task.ExactResult
+			if task.ExactResult == "" {
+				done[task.Node] = strconv.FormatFloat(task.Result, 'g', -1, 64)
+			}
+		}
+		// Невыполненные задачи будут созданы заново с новыми идентификаторами.
+		if err := db.DeleteTasksForExpressionId(expression.ID); err != nil {
+			return err
+		}
+
+		userLogin, err := db.SelectLoginForUserId(expression.UserID)
+		if err != nil {
+			fmt.Println(err)
+		}
+		id := strconv.FormatInt(expression.ID, 10)
+		data := expressionData(expression)
+		ctx, cancel := expressionContext(data.Deadline)
+		o.registry.Add(id, contract.ExpressionMapData{
+			User:    userLogin,
+			Data:    data,
+			ExpChan: make(chan contract.TaskResult),
+			Ctx:     ctx,
+			Cancel:  cancel,
+		})
+
+		log.Printf("ResumeExpressions: продолжаем вычисление выражения %s (готово операций: %d)", id, len(done))
+		go o.CalculateExpression(id, expression.Expression, done)
+	}
+
+	return nil
+}
+
+func (o *Orkestrator) CancelExpression(userLogin string, id string) (string, error) {
+	value, exist := o.registry.GetForUser(userLogin, id)
+	if !exist {
+		if _, err := o.findExpressionForId(userLogin, id); err == nil {
+			// Выражение есть в БД, но уже не вычисляется.
+			return "", calc.ErrInvalidTransition
+		}
+		return "", calc.ErrNotFound
+	}
+
+	data, err := o.setExpressionStatus(id, contract.StatusCancelled, contract.Undefined, nil)
+	if err != nil {
+		return "", err
+	}
+	value.Cancel()
+	calc.Tasks.Forget(id)
+	log.Printf("CancelExpression: выражение %s отменено пользователем %s", id, userLogin)
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes), nil
+}
+
 func Expressions(userLogin string) (string, error) {
 	var expressionsData []contract.ExpressionData
 
@@ -91,13 +315,7 @@ func Expressions(userLogin string) (string, error) {
 		expressions, err := db.SelectExpressionsForUserId(userId)
 		if err == nil {
 			for _, expression := range expressions {
-				expressionsData = append(
-					expressionsData,
-					contract.ExpressionData{
-						ID:     fmt.Sprint(expression.ID),
-						Status: expression.Status,
-						Result: expression.Result,
-					})
+				expressionsData = append(expressionsData, expressionData(expression))
 			}
 		}
 	}
@@ -110,9 +328,9 @@ func Expressions(userLogin string) (string, error) {
 	return string(jsonBytes), nil
 }
 
-func GetExpressionForId(userLogin string, id string) (string, error) {
+func (o *Orkestrator) GetExpressionForId(userLogin string, id string) (string, error) {
 
-	expression, error := findExpressionForId(userLogin, id)
+	expression, error := o.findExpressionForId(userLogin, id)
 
 	if error == nil {
 		jsonBytes, err := json.Marshal(expression)
@@ -124,68 +342,195 @@ func GetExpressionForId(userLogin string, id string) (string, error) {
 	return "", error
 }
 
+// WaitExpression ждет окончания вычисления выражения, пока не отменен ctx.
+func (o *Orkestrator) WaitExpression(ctx context.Context, userLogin string, id string) (string, error) {
+	expression, found := o.registry.GetForUser(userLogin, id)
+	if !found {
+		return o.GetExpressionForId(userLogin, id)
+	}
+
+	// В БД новое состояние попадает позже, чем к подписчикам.
+	data := expression.Data
+	if updates, unsubscribe, err := o.registry.Subscribe(id); err == nil {
+		defer unsubscribe()
+	wait:
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					break wait
+				}
+				data = update
+			case <-ctx.Done():
+				break wait
+			}
+		}
+	}
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes), nil
+}
+
+func (o *Orkestrator) ExpressionTrace(userLogin string, id string) (string, error) {
+	expression, err := o.findExpressionForId(userLogin, id)
+	if err != nil || expression.ID == "" {
+		return "", calc.ErrNotFound
+	}
+
+	intId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return "", calc.ErrNotFound
+	}
+	steps, err := db.SelectTraceForExpressionId(intId)
+	if err != nil {
+		return "", err
+	}
+
+	trace := contract.TraceData{ID: id, Steps: make([]contract.TraceStep, 0, len(steps))}
+	for _, step := range steps {
+		var operands []string
+		if err := json.Unmarshal([]byte(step.Operands), &operands); err != nil {
+			return "", err
+		}
+		trace.Steps = append(trace.Steps, contract.TraceStep{
+			TaskID:    int(step.TaskID),
+			Operation: step.Operation,
+			Operands:  operands,
+			Result:    step.Result,
+			Error:     step.Error,
+			Cached:    step.Cached,
+			Agent:     step.Agent,
+			Attempts:  step.Attempts,
+			WaitMs:    step.WaitMs,
+			ComputeMs: step.ComputeMs,
+		})
+	}
+
+	jsonBytes, err := json.Marshal(trace)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes), nil
+}
+
 func GetTaskData() (contract.TaskData, error) {
-	select {
-	case task := <-contract.TaskChannel:
-		fmt.Printf("GetTaskData: получена задача из канала: ID=%d, Arg1=%f, Arg2=%f, Operation=%s\n", task.ID, task.Arg1, task.Arg2, task.Operation)
-		return task, nil
-	default:
-		fmt.Printf("GetTaskData: канал пуст, задач нет\n")
-		return contract.TaskData{}, calc.ErrNotTask
+	for {
+		select {
+		case taskData := <-contract.TaskChannel:
+			deadline := time.Now().Add(time.Duration(taskData.OperationTime+contract.AppConfig.TASK_LEASE_MS) * time.Millisecond)
+			task, err := calc.Tasks.Lease(taskData.ID, deadline)
+			if err != nil {
+				log.Printf("GetTaskData: задача ID=%d пропущена: %v", taskData.ID, err)
+				continue
+			}
+			fmt.Printf("GetTaskData: получена задача из канала: ID=%d, Arg1=%f, Arg2=%f, Operation=%s, попытка %d\n", task.ID, task.Data.Arg1, task.Data.Arg2, task.Data.Operation, task.Attempts)
+			return taskData, nil
+		default:
+			fmt.Printf("GetTaskData: канал пуст, задач нет\n")
+			return contract.TaskData{}, calc.ErrNotTask
+		}
 	}
 }
 
-func findExpressionForId(userLogin string, id string) (contract.ExpressionData, error) {
-	var expressionData contract.ExpressionData
+// StartLeaseWatcher возвращает в очередь задачи с истекшим сроком аренды.
+func (o *Orkestrator) StartLeaseWatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			requeue, failed := calc.Tasks.Expire(now, contract.AppConfig.TASK_MAX_RETRIES)
+			for _, task := range requeue {
+				log.Printf("StartLeaseWatcher: истек срок задачи ID=%d, возвращаем в очередь", task.ID)
+				go func(taskData contract.TaskData) {
+					contract.TaskChannel <- taskData
+				}(task.Data)
+			}
+			for _, task := range failed {
+				log.Printf("StartLeaseWatcher: задача ID=%d не выполнена после %d попыток", task.ID, task.Attempts)
+				expression, exists := o.registry.Get(task.ExpressionID)
+				if !exists {
+					continue
+				}
+				go deliverResult(expression, contract.TaskResult{ID: task.ID, Err: calc.ErrTaskLeaseExpired})
+			}
+		}
+	}()
+}
+
+func (o *Orkestrator) findExpressionForId(userLogin string, id string) (contract.ExpressionData, error) {
+	var data contract.ExpressionData
 	userId, err := db.SelectIdForUser(userLogin)
 
 	if err == nil {
 		intId, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			return expressionData, err
+			return data, err
 		}
 		expression, err := db.SelectExpressionForId(intId)
 		if err != nil {
-			return expressionData, err
+			return data, err
 		}
 
 		if userId == expression.UserID {
-			expressionData = contract.ExpressionData{
-				ID:     fmt.Sprint(expression.ID),
-				Status: expression.Status,
-				Result: expression.Result,
-			}
+			data = expressionData(expression)
 		}
 
-		return expressionData, nil
+		return data, nil
 	}
 
-	name, found := contract.ExpressionMap[id]
+	expression, found := o.registry.GetForUser(userLogin, id)
 	if !found {
 		return contract.ExpressionData{}, calc.ErrNotFound
 	}
 
-	return name.Data, nil
+	return expression.Data, nil
 }
 
-func SendResult(id int, result float64) error {
-	strId := fmt.Sprint(id)
-	fmt.Printf("SendResult: получен результат для задачи ID=%d (выражение %s): %f\n", id, strId)
-	_, exists := contract.ExpressionMap[strId]
-	if exists {
-		task := contract.ExpressionMap[strId].Data
-		if task.Result != contract.Done {
-			fmt.Printf("SendResult: отправка результата %f в ExpChan для выражения %s\n", result, strId)
-			contract.ExpressionMap[strId].ExpChan <- result
-			fmt.Printf("SendResult: результат успешно отправлен\n")
-			return nil
-		} else {
-			fmt.Printf("SendResult: задача уже выполнена (Result=%s), результат не отправляется\n", task.Result)
+func (o *Orkestrator) SendResult(result contract.TaskResult) error {
+	id := result.ID
+	fmt.Printf("SendResult: получен результат для задачи ID=%d: %+v\n", id, result)
+	task, err := calc.Tasks.Finish(id)
+	if err != nil {
+		log.Printf("SendResult: результат задачи %d отклонен: %v", id, err)
+		return err
+	}
+	expression, exists := o.registry.Get(task.ExpressionID)
+	if !exists {
+		fmt.Printf("SendResult: выражение %s не найдено в реестре\n", task.ExpressionID)
+		return calc.ErrNotFound
+	}
+	fmt.Printf("SendResult: отправка результата %f в ExpChan для выражения %s\n", result.Result, task.ExpressionID)
+	if err := deliverResult(expression, result); err != nil {
+		log.Printf("SendResult: результат не принят: %v", err)
+		return err
+	}
+	fmt.Printf("SendResult: результат успешно отправлен\n")
+	return nil
+}
+
+func (o *Orkestrator) StartEviction(interval time.Duration, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if evicted := o.registry.Evict(now.Add(-retention)); evicted > 0 {
+				log.Printf("StartEviction: из реестра удалено выражений: %d", evicted)
+			}
 		}
-	} else {
-		fmt.Printf("SendResult: выражение %s не найдено в ExpressionMap\n", strId)
+	}()
+}
+
+// deliverResult отбрасывает результат, если вычисление уже прекращено.
+func deliverResult(expression contract.ExpressionMapData, result contract.TaskResult) error {
+	select {
+	case expression.ExpChan <- result:
+		return nil
+	case <-expression.Ctx.Done():
+		return calc.ErrCancelled
 	}
-	return calc.ErrNotFound
 }
 
 func getToken(login string) (string, error) {
@@ -0,0 +1,161 @@
+package orkestrator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+// Registry хранит выражения, которые вычисляются или недавно вычислены.
+type Registry struct {
+	mutex       sync.RWMutex
+	expressions map[string]*contract.ExpressionMapData
+	subscribers map[string][]chan contract.ExpressionData
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		expressions: make(map[string]*contract.ExpressionMapData),
+		subscribers: make(map[string][]chan contract.ExpressionData),
+	}
+}
+
+func (r *Registry) Add(id string, expression contract.ExpressionMapData) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.expressions[id] = &expression
+}
+
+func (r *Registry) Get(id string) (contract.ExpressionMapData, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	expression, found := r.expressions[id]
+	if !found {
+		return contract.ExpressionMapData{}, false
+	}
+	return *expression, true
+}
+
+func (r *Registry) GetForUser(userLogin string, id string) (contract.ExpressionMapData, bool) {
+	expression, found := r.Get(id)
+	if !found || expression.User != userLogin {
+		return contract.ExpressionMapData{}, false
+	}
+	return expression, true
+}
+
+// Transition сообщает о новом состоянии выражения подписчикам.
+func (r *Registry) Transition(id string, next contract.ExpressionStatus, result string, calcErr error) (contract.ExpressionData, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	expression, found := r.expressions[id]
+	if !found {
+		return contract.ExpressionData{}, calc.ErrNotFound
+	}
+	if !expression.Data.Status.CanBecome(next) {
+		return expression.Data, calc.ErrInvalidTransition
+	}
+
+	now := time.Now()
+	data := &expression.Data
+	data.Status = next
+	data.Result = result
+	describeResult(data)
+	if next == contract.StatusRunning {
+		data.StartedAt = &now
+	}
+	if next.Final() {
+		data.FinishedAt = &now
+	}
+	if calcErr != nil {
+		data.ErrorCode = calc.ErrorCode(calcErr)
+		data.ErrorMessage = calcErr.Error()
+	}
+
+	r.notify(id, *data)
+	return *data, nil
+}
+
+func (r *Registry) SetAssignments(id string, assignments []contract.Assignment) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if expression, found := r.expressions[id]; found {
+		expression.Data.Assignments = assignments
+	}
+}
+
+// Subscribe возвращает канал состояний выражения, который закрывается после окончательного.
+func (r *Registry) Subscribe(id string) (updates <-chan contract.ExpressionData, unsubscribe func(), err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	expression, found := r.expressions[id]
+	if !found {
+		return nil, nil, calc.ErrNotFound
+	}
+
+	// Состояний всего пять, поэтому отправка в буфер не блокируется.
+	ch := make(chan contract.ExpressionData, 5)
+	if expression.Data.Status.Final() {
+		ch <- expression.Data
+		close(ch)
+		return ch, func() {}, nil
+	}
+	r.subscribers[id] = append(r.subscribers[id], ch)
+
+	unsubscribe = func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		subscribers := r.subscribers[id]
+		for i, subscriber := range subscribers {
+			if subscriber == ch {
+				r.subscribers[id] = append(subscribers[:i], subscribers[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+		if len(r.subscribers[id]) == 0 {
+			delete(r.subscribers, id)
+		}
+	}
+	return ch, unsubscribe, nil
+}
+
+// notify вызывается под блокировкой r.mutex.
+func (r *Registry) notify(id string, data contract.ExpressionData) {
+	for _, subscriber := range r.subscribers[id] {
+		select {
+		case subscriber <- data:
+		default:
+		}
+		if data.Status.Final() {
+			close(subscriber)
+		}
+	}
+	if data.Status.Final() {
+		delete(r.subscribers, id)
+	}
+}
+
+// Evict удаляет выражения, завершенные раньше before; они остаются в БД.
+func (r *Registry) Evict(before time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	evicted := 0
+	for id, expression := range r.expressions {
+		finishedAt := expression.Data.FinishedAt
+		if expression.Data.Status.Final() && finishedAt != nil && finishedAt.Before(before) {
+			delete(r.expressions, id)
+			evicted++
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,142 @@
+package orkestrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+func queued(user string) contract.ExpressionMapData {
+	return contract.ExpressionMapData{
+		User: user,
+		Data: contract.ExpressionData{Status: contract.StatusQueued},
+	}
+}
+
+func TestRegistryOwnerLookup(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add("1", queued("alice"))
+
+	if _, found := registry.GetForUser("alice", "1"); !found {
+		t.Errorf("owner must see the expression")
+	}
+	if _, found := registry.GetForUser("bob", "1"); found {
+		t.Errorf("other users must not see the expression")
+	}
+}
+
+func TestRegistrySubscribe(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add("1", queued("alice"))
+
+	updates, unsubscribe, err := registry.Subscribe("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer unsubscribe()
+
+	if _, err := registry.Transition("1", contract.StatusRunning, contract.Undefined, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := registry.Transition("1", contract.StatusDone, "3.000", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := registry.Transition("1", contract.StatusFailed, contract.Undefined, nil); err != calc.ErrInvalidTransition {
+		t.Errorf("expected ErrInvalidTransition, got %v", err)
+	}
+
+	var statuses []contract.ExpressionStatus
+	for data := range updates {
+		statuses = append(statuses, data.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != contract.StatusRunning || statuses[1] != contract.StatusDone {
+		t.Errorf("unexpected updates: %v", statuses)
+	}
+}
+
+func TestRegistryEvictsFinishedExpressions(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add("1", queued("alice"))
+	registry.Add("2", queued("alice"))
+	registry.Transition("1", contract.StatusCancelled, contract.Undefined, nil)
+
+	if evicted := registry.Evict(time.Now().Add(time.Second)); evicted != 1 {
+		t.Errorf("expected 1 evicted expression, got %d", evicted)
+	}
+	if _, found := registry.Get("1"); found {
+		t.Errorf("finished expression must be evicted")
+	}
+	if _, found := registry.Get("2"); !found {
+		t.Errorf("queued expression must stay in registry")
+	}
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	registry := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			registry.Add(id, queued("alice"))
+			registry.Get(id)
+			registry.Transition(id, contract.StatusRunning, contract.Undefined, nil)
+			registry.Evict(time.Now())
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+}
+
+func TestRegistryAssignments(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add("1", queued("alice"))
+	updates, unsubscribe, err := registry.Subscribe("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer unsubscribe()
+
+	registry.Transition("1", contract.StatusRunning, contract.Undefined, nil)
+	registry.SetAssignments("1", []contract.Assignment{{Name: "a", Result: "3.000"}})
+	registry.Transition("1", contract.StatusDone, "9.000", nil)
+
+	var last contract.ExpressionData
+	for data := range updates {
+		last = data
+	}
+	if last.Status != contract.StatusDone || len(last.Assignments) != 1 || last.Assignments[0].Name != "a" {
+		t.Errorf("expected assignments with the final state, got %+v", last)
+	}
+}
+
+func TestWaitExpression(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add("1", queued("alice"))
+	o := New(registry)
+
+	// Без окончания вычисления ответ приходит по истечении ctx.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, err := o.WaitExpression(ctx, "alice", "1")
+	if err != nil || !strings.Contains(result, `"status":"QUEUED"`) {
+		t.Errorf("expected the queued state, got %s, %v", result, err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		registry.Transition("1", contract.StatusRunning, contract.Undefined, nil)
+		registry.Transition("1", contract.StatusDone, "3.000", nil)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err = o.WaitExpression(ctx, "alice", "1")
+	if err != nil || !strings.Contains(result, `"status":"DONE"`) || !strings.Contains(result, `"result":"3.000"`) {
+		t.Errorf("expected the final state, got %s, %v", result, err)
+	}
+}
@@ -0,0 +1,40 @@
+package orkestrator
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+)
+
+func scriptAssignments(result calc.ScriptResult) []contract.Assignment {
+	assignments := make([]contract.Assignment, 0, len(result.Assignments))
+	for _, assignment := range result.Assignments {
+		assignments = append(assignments, contract.Assignment{
+			Name:   assignment.Name,
+			Result: calc.FormatResult(assignment.Value),
+		})
+	}
+	return assignments
+}
+
+func encodeAssignments(assignments []contract.Assignment) string {
+	if len(assignments) == 0 {
+		return ""
+	}
+	jsonBytes, err := json.Marshal(assignments)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes)
+}
+
+func decodeAssignments(encoded string, assignments *[]contract.Assignment) {
+	if encoded == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(encoded), assignments); err != nil {
+		log.Printf("decodeAssignments: не удалось прочитать %q: %v", encoded, err)
+	}
+}
@@ -0,0 +1,106 @@
+package orkestrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+	"github.com/veronicashkarova/server-for-calc/pkg/db"
+)
+
+func (o *Orkestrator) setExpressionStatus(id string, next contract.ExpressionStatus, result string, calcErr error) (contract.ExpressionData, error) {
+	data, err := o.registry.Transition(id, next, result, calcErr)
+	if err != nil {
+		log.Printf("setExpressionStatus: выражение %s нельзя перевести из %s в %s: %v", id, data.Status, next, err)
+		return data, err
+	}
+
+	intId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return data, err
+	}
+	return data, db.UpdateExpressionState(&db.Expression{
+		ID:           intId,
+		Status:       string(data.Status),
+		Result:       data.Result,
+		ErrorCode:    data.ErrorCode,
+		ErrorMessage: data.ErrorMessage,
+		StartedAt:    nullTime(data.StartedAt),
+		FinishedAt:   nullTime(data.FinishedAt),
+		Assignments:  encodeAssignments(data.Assignments),
+	})
+}
+
+func expressionData(expression db.Expression) contract.ExpressionData {
+	data := contract.ExpressionData{
+		ID:           fmt.Sprint(expression.ID),
+		Status:       contract.ExpressionStatus(expression.Status),
+		Result:       expression.Result,
+		ErrorCode:    expression.ErrorCode,
+		ErrorMessage: expression.ErrorMessage,
+		CreatedAt:    timePointer(expression.CreatedAt),
+		StartedAt:    timePointer(expression.StartedAt),
+		FinishedAt:   timePointer(expression.FinishedAt),
+		Deadline:     timePointer(expression.Deadline),
+		Precision:    expression.Precision,
+		Optimize:     expression.Optimize,
+		SavedTasks:   expression.SavedTasks,
+		FoldLimit:    expression.FoldLimit,
+		Cache:        expression.Cache,
+		Script:       expression.Script,
+	}
+	describeResult(&data)
+	decodeAssignments(expression.Assignments, &data.Assignments)
+	decodeNames(expression.Variables, &data.Variables)
+	decodeNames(expression.Functions, &data.Functions)
+	return data
+}
+
+// describeResult добавляет запись результата, которая зависит от режима вычисления.
+func describeResult(data *contract.ExpressionData) {
+	precision := calc.Precision(data.Precision)
+	data.Approximation = precision.Approximate(data.Result)
+	data.Hex = precision.Hex(data.Result)
+	data.Complex = precision.Complex(data.Result)
+}
+
+func expressionScope(data contract.ExpressionData) calc.Scope {
+	precision, err := calc.ParsePrecision(data.Precision)
+	if err != nil {
+		log.Printf("expressionScope: выражение %s: неизвестный режим %q, используется float", data.ID, data.Precision)
+	}
+	return calc.Scope{
+		Precision: precision,
+		Variables: data.Variables,
+		Functions: data.Functions,
+		Optimize:  data.Optimize,
+		FoldLimit: data.FoldLimit,
+		Cache:     data.Cache,
+	}
+}
+
+func expressionContext(deadline *time.Time) (context.Context, context.CancelFunc) {
+	if deadline == nil {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), *deadline)
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timePointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,93 @@
+package orkestrator
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/veronicashkarova/server-for-calc/pkg/calc"
+	"github.com/veronicashkarova/server-for-calc/pkg/contract"
+	"github.com/veronicashkarova/server-for-calc/pkg/db"
+)
+
+// Выражения, принятые раньше, продолжают использовать прежнее значение.
+func SetVariable(userLogin string, variable contract.Variable) error {
+	if err := calc.CheckVariableName(variable.Name); err != nil {
+		return err
+	}
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return err
+	}
+	return db.UpsertVariable(&db.Variable{UserID: userId, Name: variable.Name, Value: variable.Value})
+}
+
+func Variables(userLogin string) (string, error) {
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return "", err
+	}
+	variables, err := db.SelectVariablesForUserId(userId)
+	if err != nil {
+		return "", err
+	}
+
+	data := contract.VariablesData{Variables: []contract.Variable{}}
+	for _, variable := range variables {
+		data.Variables = append(data.Variables, contract.Variable{Name: variable.Name, Value: variable.Value})
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes), nil
+}
+
+func DeleteVariable(userLogin string, name string) error {
+	userId, err := db.SelectIdForUser(userLogin)
+	if err != nil {
+		return err
+	}
+	deleted, err := db.DeleteVariable(userId, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return calc.ErrVariableNotFound
+	}
+	return nil
+}
+
+// Ошибки разбора здесь не важны: выражение все равно завершится с ошибкой.
+func usedVariables(userId int64, expression string) map[string]float64 {
+	variables, err := db.SelectVariablesForUserId(userId)
+	if err != nil {
+		log.Printf("usedVariables: не удалось получить переменные пользователя %d: %v", userId, err)
+		return nil
+	}
+	values := make(map[string]float64, len(variables))
+	for _, variable := range variables {
+		values[variable.Name] = variable.Value
+	}
+	used, _ := calc.UsedVariables(expression, values)
+	return used
+}
+
+func encodeNames[T any](names map[string]T) string {
+	if len(names) == 0 {
+		return ""
+	}
+	jsonBytes, err := json.Marshal(names)
+	if err != nil {
+		panic(err)
+	}
+	return string(jsonBytes)
+}
+
+func decodeNames[T any](encoded string, names *map[string]T) {
+	if encoded == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(encoded), names); err != nil {
+		log.Printf("decodeNames: не удалось прочитать %q: %v", encoded, err)
+	}
+}